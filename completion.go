@@ -0,0 +1,464 @@
+package main
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	"github.com/WhiskeyJack96/logseqlsp/graph"
+	"github.com/tliron/glsp"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"golang.org/x/exp/slog"
+	"math/bits"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// completionLimit caps the items of a completion list. The list is marked incomplete when more candidates match so
+// the client asks again as more of the name is typed.
+const completionLimit = 100
+
+// candidate is a possible completion, ranked against what has been typed so far by fuzzyScore.
+type candidate struct {
+	label  string
+	kind   protocol.CompletionItemKind
+	detail string
+	// uri is the page documentation is shown for when the item is resolved
+	uri protocol.DocumentUri
+	// insert replaces the completed text, the label followed by the list's suffix when empty
+	insert string
+	// filter is matched by the client against the completed text, the label when empty
+	filter string
+	// documentation is shown as markdown along with the item
+	documentation string
+	// snippet is true when insert is a snippet with placeholders
+	snippet bool
+	// rng replaces the range of the list when set
+	rng *protocol.Range
+	// edits are made to the completed document when the item is accepted, command is run afterwards
+	edits   []protocol.TextEdit
+	command *protocol.Command
+	score   int
+}
+
+func (gi *graphInfo) completion(context *glsp.Context, params *protocol.CompletionParams) (any, error) {
+	d, err := gi.readDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	if d.InCode(params.Position) {
+		return nil, nil
+	}
+	cursor := d.Offset(params.Position)
+	lineStart := d.Offset(protocol.Position{Line: params.Position.Line})
+	before, after := d.Contents[lineStart:cursor], d.Contents[cursor:]
+	if i := strings.LastIndex(before, "[["); i != -1 && !strings.Contains(before[i:], "]]") {
+		// the closing brackets are left alone when the editor already paired them
+		closing := "]]"
+		if strings.HasPrefix(after, "]]") {
+			closing = ""
+		}
+		rng := protocol.Range{Start: d.Position(lineStart + i + 2), End: params.Position}
+		return completionList(gi.pageCandidates(before[i+2:]), rng, closing), nil
+	}
+	if i := strings.LastIndex(before, "(("); i != -1 && !strings.Contains(before[i:], "))") {
+		closing := "))"
+		if strings.HasPrefix(after, "))") {
+			closing = ""
+		}
+		rng := protocol.Range{Start: d.Position(lineStart + i + 2), End: params.Position}
+		return completionList(gi.blockCandidates(params.TextDocument.URI, d, params.Position, before[i+2:], closing), rng, ""), nil
+	}
+	if i := strings.LastIndexByte(before, '/'); i != -1 && (i == 0 || isSpace(before[i-1])) && !strings.ContainsAny(before[i+1:], " \t") {
+		rng := protocol.Range{Start: d.Position(lineStart + i), End: params.Position}
+		rest, _, _ := strings.Cut(after, "\n")
+		return completionList(gi.slashCandidates(d, params.Position, rng, before[:i], rest, before[i+1:]), rng, ""), nil
+	}
+	if i := strings.LastIndexByte(before, '#'); i != -1 && (i == 0 || isSpace(before[i-1])) && !strings.ContainsAny(before[i+1:], tagTerminators) {
+		rng := protocol.Range{Start: d.Position(lineStart + i), End: params.Position}
+		return completionList(gi.tagCandidates(before[i+1:]), rng, ""), nil
+	}
+	if match := propertyValueRegex.FindStringSubmatchIndex(before); match != nil {
+		// only the comma separated item under the cursor is completed
+		spans := document.PropertyValueSpans(before[match[4]:])
+		start := match[4] + spans[len(spans)-1][0]
+		start += len(before[start:]) - len(strings.TrimLeft(before[start:], " \t"))
+		rng := protocol.Range{Start: d.Position(lineStart + start), End: params.Position}
+		return completionList(gi.propertyValueCandidates(d, before[match[2]:match[3]], before[start:]), rng, ""), nil
+	}
+	if match := propertyKeyRegex.FindStringSubmatchIndex(before); match != nil {
+		// a key followed by :: is replaced along with the colons, otherwise the key is being edited in front of them
+		suffix := ""
+		if match[4] != -1 {
+			suffix = ":: "
+		} else if !strings.HasPrefix(after, "::") {
+			return nil, nil
+		}
+		rng := protocol.Range{Start: d.Position(lineStart + match[2]), End: params.Position}
+		return completionList(gi.propertyKeyCandidates(before[match[2]:match[3]]), rng, suffix), nil
+	}
+	return nil, nil
+}
+
+// propertyKeyRegex matches the start of a line up to a property key being typed, followed by the :: that ends it
+// when the cursor is after them. propertyValueRegex matches a property line up to the value being typed.
+var propertyKeyRegex = regexp.MustCompile(`^[ \t]*(?:-[ \t]+)?([^ \t:]*)(::)?$`)
+var propertyValueRegex = regexp.MustCompile(`^[ \t]*(?:-[ \t]+)?([^ \t]+?)::[ \t]+(.*)$`)
+
+// builtinProperties are the properties logseq gives a meaning to, with the values they take when there is a fixed set.
+var builtinProperties = map[string][]string{
+	"alias":                     nil,
+	"tags":                      nil,
+	"title":                     nil,
+	"icon":                      nil,
+	"filters":                   nil,
+	"template":                  nil,
+	"template-including-parent": {"true", "false"},
+	"collapsed":                 {"true", "false"},
+	"public":                    {"true", "false"},
+	"exclude-from-graph-view":   {"true", "false"},
+	"heading":                   {"true", "1", "2", "3", "4", "5", "6"},
+	"background-color":          {"yellow", "red", "pink", "green", "blue", "purple", "gray"},
+}
+
+// propertyKeyCandidates ranks the property keys used in the graph and the built-in ones against query. Keys set on
+// more blocks rank higher.
+func (gi *graphInfo) propertyKeyCandidates(query string) []candidate {
+	var candidates []candidate
+	keys := gi.index().PropertyKeys()
+	for key := range builtinProperties {
+		if _, ok := keys[key]; !ok {
+			keys[key] = 0
+		}
+	}
+	for key, n := range keys {
+		score, ok := fuzzyScore(query, key)
+		if !ok {
+			continue
+		}
+		c := candidate{label: key, kind: protocol.CompletionItemKindProperty, detail: fmt.Sprintf("used %d times", n), score: score + usageScore(n)}
+		if _, builtin := builtinProperties[key]; builtin && n == 0 {
+			c.detail = "built-in property"
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// propertyValueCandidates ranks the values the graph sets the property key to against query, along with the fixed
+// values of built-in properties and, for properties d treats as lists of pages, the pages of the graph.
+func (gi *graphInfo) propertyValueCandidates(d document.Document, key string, query string) []candidate {
+	key = strings.ToLower(key)
+	values := gi.index().PropertyValues(key)
+	for _, value := range builtinProperties[key] {
+		if _, ok := values[value]; !ok {
+			values[value] = 0
+		}
+	}
+	var candidates []candidate
+	seen := map[string]bool{}
+	for value, n := range values {
+		score, ok := fuzzyScore(query, value)
+		if !ok {
+			continue
+		}
+		seen[graph.NormalizeName(value)] = true
+		c := candidate{label: value, kind: protocol.CompletionItemKindValue, detail: fmt.Sprintf("used %d times", n), score: score + usageScore(n)}
+		if n == 0 {
+			c.detail = "built-in value"
+		}
+		candidates = append(candidates, c)
+	}
+	if d.SeparatedByCommas(key) {
+		for _, c := range gi.pageCandidates(query) {
+			if !seen[graph.NormalizeName(c.label)] {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	return candidates
+}
+
+// blockCandidates searches the blocks of the graph for the words of query and completes them to a ((uuid)) reference
+// closed with closing, leaving out the block at pos that is being typed in. Blocks of the document being edited are
+// read from it rather than from the index, which only knows the saved file. A block without an id is given one, in the
+// same edit when it is in the edited document and through addBlockIDCommand otherwise.
+func (gi *graphInfo) blockCandidates(uri protocol.DocumentUri, d document.Document, pos protocol.Position, query string, closing string) []candidate {
+	words := strings.Fields(strings.ToLower(query))
+	if len(words) == 0 {
+		return nil
+	}
+	var candidates []candidate
+	// add completes a block whose text contains every word
+	add := func(b graph.Block, edit func(id string) []protocol.TextEdit) {
+		lower := strings.ToLower(b.Text)
+		c := candidate{
+			label:         blockLabel(b.Text),
+			kind:          protocol.CompletionItemKindText,
+			detail:        b.Page,
+			filter:        query,
+			documentation: "- " + strings.ReplaceAll(b.Text, "\n", "\n  "),
+			score:         -len(b.Text),
+		}
+		switch {
+		case strings.HasPrefix(lower, strings.ToLower(query)):
+			c.score += 2000
+		case strings.Contains(lower, strings.ToLower(query)):
+			c.score += 1000
+		}
+		id := b.UUID
+		if id == "" {
+			var err error
+			if id, err = newBlockID(); err != nil {
+				gi.logger.Warn("could not generate a block id", slog.Any("err", err))
+				return
+			}
+			if edit != nil {
+				c.edits = edit(id)
+			} else {
+				c.command = &protocol.Command{Title: "Add block id", Command: addBlockIDCommand, Arguments: []any{b.URI, b.Range.Start.Line, id}}
+			}
+		}
+		c.insert = id + closing
+		candidates = append(candidates, c)
+	}
+	gi.index().SearchBlocks(words, func(b graph.Block) bool {
+		if b.URI != uri {
+			add(b, nil)
+		}
+		return true
+	})
+	page := ""
+	if saved, ok := gi.index().PageForURI(uri); ok {
+		page = saved.Name
+	}
+	d.Walk(func(b *document.Block) bool {
+		if !b.Bullet || d.RangeContains(b.Range, pos) {
+			return true
+		}
+		text := strings.TrimSpace(strings.Join(b.Text(), "\n"))
+		lower := strings.ToLower(text)
+		for _, word := range words {
+			if !strings.Contains(lower, word) {
+				return true
+			}
+		}
+		id, _ := b.Property("id")
+		add(graph.Block{UUID: id, Page: page, URI: uri, Range: b.Range, Text: text}, func(id string) []protocol.TextEdit {
+			return []protocol.TextEdit{d.IDEdit(b, id)}
+		})
+		return true
+	})
+	return candidates
+}
+
+// blockLabel shortens the text of a block to its first line, cut off after 60 characters.
+func blockLabel(text string) string {
+	label, _, _ := strings.Cut(text, "\n")
+	if r := []rune(label); len(r) > 60 {
+		label = string(r[:60]) + "…"
+	}
+	return label
+}
+
+// newBlockID returns a random version 4 uuid, the form logseq gives block ids.
+func newBlockID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// tagTerminators are the characters that end the query of a #tag being typed.
+const tagTerminators = " \t,;\"'()[]{}#"
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t'
+}
+
+// tagCandidates ranks the pages of the graph against query for a #tag, switching to #[[tag]] for names a plain tag
+// cannot hold.
+func (gi *graphInfo) tagCandidates(query string) []candidate {
+	candidates := gi.pageCandidates(query)
+	for i, c := range candidates {
+		candidates[i].filter = "#" + c.label
+		candidates[i].insert = "#" + c.label
+		if !document.IsPlainTag(c.label) {
+			candidates[i].insert = "#[[" + c.label + "]]"
+		}
+	}
+	return candidates
+}
+
+// pageCandidates ranks the pages and aliases of the graph, and the pages linked to that have no file yet, against
+// query. Pages that are linked to more often rank higher.
+func (gi *graphInfo) pageCandidates(query string) []candidate {
+	var candidates []candidate
+	seen := map[string]bool{}
+	add := func(c candidate, usage int) {
+		key := graph.NormalizeName(c.label)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		if score, ok := fuzzyScore(query, c.label); ok {
+			c.score = score + usageScore(usage)
+			candidates = append(candidates, c)
+		}
+	}
+	for _, page := range gi.index().Pages() {
+		c := candidate{label: page.Name, kind: protocol.CompletionItemKindFile, detail: "page", uri: page.URI}
+		switch {
+		case page.Journal:
+			c.kind, c.detail = protocol.CompletionItemKindEvent, "journal"
+		case strings.Contains(page.Name, "/"):
+			c.kind, c.detail = protocol.CompletionItemKindModule, "namespace "+document.NamespaceParent(page.Name)
+		}
+		// links to an alias are links to the page
+		usage := gi.index().Usage(page.Name)
+		for _, alias := range page.Aliases {
+			usage += gi.index().Usage(alias)
+		}
+		add(c, usage)
+		for _, alias := range page.Aliases {
+			add(candidate{label: alias, kind: protocol.CompletionItemKindReference, detail: "alias of " + page.Name, uri: page.URI}, usage)
+		}
+	}
+	for _, name := range gi.index().ReferencedNames() {
+		add(candidate{label: name, kind: protocol.CompletionItemKindFile, detail: "page without a file"}, gi.index().Usage(name))
+	}
+	return candidates
+}
+
+// usageScore turns the number of links to a page into a bonus for fuzzyScore that grows with the order of magnitude of
+// the usage, so a heavily used page beats a slightly closer match but not a prefix match. The bonus stops growing at 8
+// links, below the 1000 fuzzyScore gives a prefix match.
+func usageScore(usage int) int {
+	magnitude := bits.Len(uint(usage))
+	if magnitude > 4 {
+		magnitude = 4
+	}
+	return 200 * magnitude
+}
+
+// completionList turns the best candidates into completion items that replace rng with the candidate and suffix.
+func completionList(candidates []candidate, rng protocol.Range, suffix string) protocol.CompletionList {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return graph.NormalizeName(candidates[i].label) < graph.NormalizeName(candidates[j].label)
+	})
+	list := protocol.CompletionList{Items: []protocol.CompletionItem{}}
+	if len(candidates) > completionLimit {
+		candidates, list.IsIncomplete = candidates[:completionLimit], true
+	}
+	for i, c := range candidates {
+		kind, detail, filter, insert := c.kind, c.detail, c.filter, c.insert
+		if filter == "" {
+			filter = c.label
+		}
+		if insert == "" {
+			insert = c.label + suffix
+		}
+		sortText := fmt.Sprintf("%04d", i)
+		edit := protocol.TextEdit{Range: rng, NewText: insert}
+		if c.rng != nil {
+			edit.Range = *c.rng
+		}
+		item := protocol.CompletionItem{
+			Label:      c.label,
+			Kind:       &kind,
+			SortText:   &sortText,
+			FilterText: &filter,
+			TextEdit:   edit,
+		}
+		if c.snippet {
+			format := protocol.InsertTextFormatSnippet
+			item.InsertTextFormat = &format
+		}
+		if detail != "" {
+			item.Detail = &detail
+		}
+		if c.documentation != "" {
+			item.Documentation = protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: c.documentation}
+		}
+		if len(c.edits) > 0 {
+			item.AdditionalTextEdits = c.edits
+		}
+		item.Command = c.command
+		if c.uri != "" {
+			item.Data = map[string]any{"uri": c.uri}
+		}
+		list.Items = append(list.Items, item)
+	}
+	return list
+}
+
+// completionResolve adds the first blocks of the page a completion item refers to as its documentation.
+func (gi *graphInfo) completionResolve(context *glsp.Context, item *protocol.CompletionItem) (*protocol.CompletionItem, error) {
+	data, ok := item.Data.(map[string]any)
+	if !ok {
+		return item, nil
+	}
+	uri, ok := data["uri"].(string)
+	if !ok {
+		return item, nil
+	}
+	d, err := gi.readDocument(uri)
+	if err != nil {
+		gi.logger.Warn("could not read completion page", slog.Any("err", err), slog.String("uri", uri))
+		return item, nil
+	}
+	item.Documentation = pagePreview(d, 5)
+	return item, nil
+}
+
+// pagePreview renders the first n top level blocks of a page.
+func pagePreview(d document.Document, n int) protocol.MarkupContent {
+	s := protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown}
+	for _, b := range d.Blocks {
+		if n == 0 {
+			break
+		}
+		text := b.Text()
+		if !b.Bullet || len(text) == 0 {
+			continue
+		}
+		s.Value = s.Value + "- " + strings.Join(text, "\n  ") + "\n"
+		n--
+	}
+	return s
+}
+
+// fuzzyScore reports whether the characters of query appear in order in label, ignoring case, and scores the match.
+// Consecutive characters, characters at the start of a word and a matching prefix raise the score, longer labels
+// lower it.
+func fuzzyScore(query string, label string) (int, bool) {
+	q, l := []rune(strings.ToLower(query)), []rune(strings.ToLower(label))
+	score, matched, previous := 0, 0, -2
+	for i := 0; i < len(l) && matched < len(q); i++ {
+		if l[i] != q[matched] {
+			continue
+		}
+		score++
+		if i == previous+1 {
+			score += 4
+		}
+		if i == 0 || strings.ContainsRune(" /-_.", l[i-1]) {
+			score += 2
+		}
+		previous = i
+		matched++
+	}
+	if matched < len(q) {
+		return 0, false
+	}
+	if strings.HasPrefix(string(l), string(q)) {
+		score += 10
+	}
+	return score*100 - len(l), true
+}
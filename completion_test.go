@@ -0,0 +1,307 @@
+package main
+
+import (
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+	"testing"
+)
+
+func TestFuzzyScore(t *testing.T) {
+	if _, ok := fuzzyScore("kbn", "Kubernetes"); !ok {
+		t.Error("kbn should match Kubernetes")
+	}
+	if _, ok := fuzzyScore("xyz", "Kubernetes"); ok {
+		t.Error("xyz should not match Kubernetes")
+	}
+	ranked := []string{"project", "projects/backend", "my project", "p-r-o-j"}
+	previous := 1 << 30
+	for _, label := range ranked {
+		score, ok := fuzzyScore("proj", label)
+		if !ok {
+			t.Fatalf("proj should match %s", label)
+		}
+		if score >= previous {
+			t.Errorf("%s scored %d, not below the previous label's %d", label, score, previous)
+		}
+		previous = score
+	}
+}
+
+func TestUsageScore(t *testing.T) {
+	prefix, _ := fuzzyScore("pro", "project")
+	heavilyUsed, _ := fuzzyScore("pro", "my project")
+	if heavilyUsed+usageScore(1<<20) >= prefix+usageScore(0) {
+		t.Error("a heavily used page outranks a prefix match")
+	}
+	closer, _ := fuzzyScore("pro", "project")
+	longer, _ := fuzzyScore("pro", "project plan")
+	if longer+usageScore(32) <= closer {
+		t.Error("usage does not make up for a slightly closer match")
+	}
+}
+
+func TestSnippetText(t *testing.T) {
+	for snippet, want := range map[string]string{
+		"[[${1:Oct 17th, 2026}]]":        "[[Oct 17th, 2026]]",
+		"[${2:label}](${1:url})":         "[label](url)",
+		"{{query $1}}":                   "{{query }}",
+		"\\$\\$\n  $0\n  \\$\\$":         "$$\n  \n  $$",
+		snippetEscape(`a $b} \c`) + "$0": `a $b} \c`,
+	} {
+		if got := snippetText(snippet); got != want {
+			t.Errorf("snippetText(%q) = %q, want %q", snippet, got, want)
+		}
+	}
+}
+
+// complete opens text as the document at name, relative to the graph of gi, and completes at the | in it. The items
+// are returned by label.
+func complete(t *testing.T, gi *graphInfo, name string, text string) map[string]protocol.CompletionItem {
+	t.Helper()
+	uri := gi.uri(name)
+	i := strings.Index(text, "|")
+	d, err := gi.documents.Open(uri, text[:i]+text[i+1:], gi.documentOptions(uri)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gi.documents.Close(uri)
+	result, err := gi.completion(nil, &protocol.CompletionParams{TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Position:     d.Position(i),
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := map[string]protocol.CompletionItem{}
+	if list, ok := result.(protocol.CompletionList); ok {
+		for _, item := range list.Items {
+			items[item.Label] = item
+		}
+	}
+	return items
+}
+
+func TestBlockCompletion(t *testing.T) {
+	const id = "11111111-2222-4333-8444-555555555555"
+	gi := newTestGraph(t, map[string]string{
+		"pages/other.md": "- saved cat with id\n  id:: " + id + "\n- saved cat without id\n",
+		"pages/edit.md":  "- saved cat in the edited page\n",
+	})
+	items := complete(t, gi, "pages/edit.md", "- local block about cats\n- ((Cat|\n")
+	// the block being typed in matches its own query but is left out
+	if len(items) != 3 {
+		t.Fatalf("got %d items %v, want 3", len(items), items)
+	}
+	if _, ok := items["saved cat in the edited page"]; ok {
+		t.Error("completed a block of the saved file instead of the open document")
+	}
+
+	withID := items["saved cat with id"]
+	if edit := withID.TextEdit.(protocol.TextEdit); edit.NewText != id+"))" || edit.Range.Start != (protocol.Position{Line: 1, Character: 4}) {
+		t.Errorf("edit = %+v, want the id closed with ))", edit)
+	}
+	if withID.Command != nil || withID.AdditionalTextEdits != nil {
+		t.Errorf("block with an id was given another: %+v", withID)
+	}
+
+	// a block of another file is given its id by a command, as the edit can only change the edited document
+	saved := items["saved cat without id"]
+	if saved.Command == nil || saved.Command.Command != addBlockIDCommand || saved.AdditionalTextEdits != nil {
+		t.Fatalf("block of another file = %+v, want the add block id command", saved)
+	}
+	arguments := saved.Command.Arguments
+	if arguments[0] != gi.uri("pages/other.md") || arguments[1] != protocol.UInteger(2) || arguments[2].(string)+"))" != saved.TextEdit.(protocol.TextEdit).NewText {
+		t.Errorf("command arguments = %v, want the block and the inserted id", arguments)
+	}
+
+	local := items["local block about cats"]
+	if local.Command != nil || len(local.AdditionalTextEdits) != 1 || !strings.HasPrefix(local.AdditionalTextEdits[0].NewText, "\n  id:: ") {
+		t.Errorf("block of the edited document = %+v, want the id:: property in the same edit", local)
+	}
+
+	items = complete(t, gi, "pages/edit.md", "- ((cat|))\n")
+	if edit := items["saved cat with id"].TextEdit.(protocol.TextEdit); edit.NewText != id {
+		t.Errorf("insert = %q, want the id alone when the editor paired the parentheses", edit.NewText)
+	}
+}
+
+func TestCompletionSkipsCode(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"pages/page.md": "- text\n"})
+	for _, text := range []string{
+		"- `[[pa|`",
+		"- ```\n  /|\n  ```",
+		"- #+BEGIN_SRC\n  #pa|\n  #+END_SRC",
+	} {
+		if items := complete(t, gi, "pages/edit.md", text); len(items) != 0 {
+			t.Errorf("completed %d items in the code of %q", len(items), text)
+		}
+	}
+	if items := complete(t, gi, "pages/edit.md", "- `code` [[pa|"); len(items) == 0 {
+		t.Error("no completion after inline code")
+	}
+}
+
+func TestSlashMarkerCompletion(t *testing.T) {
+	gi := newTestGraph(t, nil)
+	tests := []struct {
+		text       string
+		wantText   string
+		wantFilter string
+		wantStart  protocol.Position
+	}{
+		{text: "- some text /TO|", wantText: "TODO some text ", wantFilter: "some text /TODO", wantStart: protocol.Position{Line: 0, Character: 2}},
+		{text: "- first\n  - DOING task /TO|\n", wantText: "TODO task ", wantFilter: "DOING task /TODO", wantStart: protocol.Position{Line: 1, Character: 4}},
+	}
+	for _, test := range tests {
+		item, ok := complete(t, gi, "pages/edit.md", test.text)["TODO"]
+		if !ok {
+			t.Errorf("no TODO item for %q", test.text)
+			continue
+		}
+		edit := item.TextEdit.(protocol.TextEdit)
+		cursor := protocol.Position{Line: test.wantStart.Line, Character: protocol.UInteger(len(strings.Split(test.text, "\n")[test.wantStart.Line]) - 1)}
+		if edit.NewText != test.wantText || edit.Range.Start != test.wantStart || edit.Range.End != cursor {
+			t.Errorf("edit of %q = %+v, want %q from %+v to %+v", test.text, edit, test.wantText, test.wantStart, cursor)
+		}
+		if *item.FilterText != test.wantFilter {
+			t.Errorf("filter text of %q = %q, want %q", test.text, *item.FilterText, test.wantFilter)
+		}
+	}
+}
+
+func TestTemplateText(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{
+		"pages/templates.md": "- meeting\n  template:: meeting\n  id:: 11111111-2222-4333-8444-555555555555\n  - agenda\n    ID:: 11111111-2222-4333-8444-666666666666\n    - item\n  - notes\n" +
+			"- full\n  template:: full\n  template-including-parent:: true\n  - child\n",
+	})
+	items := complete(t, gi, "pages/edit.md", "- parent\n  - /Templ|\n")
+	for label, want := range map[string]string{
+		"Template: meeting": "  - agenda\n    - item\n  - notes",
+		"Template: full":    "  - full\n    - child",
+	} {
+		item, ok := items[label]
+		if !ok {
+			t.Errorf("no item %q in %v", label, items)
+			continue
+		}
+		edit := item.TextEdit.(protocol.TextEdit)
+		if edit.NewText != want {
+			t.Errorf("%s inserts %q, want %q", label, edit.NewText, want)
+		}
+		if edit.Range.Start != (protocol.Position{Line: 1}) || edit.Range.End != (protocol.Position{Line: 1, Character: 10}) {
+			t.Errorf("%s replaces %+v, want the whole line", label, edit.Range)
+		}
+	}
+}
+
+func TestPageCompletion(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"pages/Project Plan.md": "- text\n"})
+	tests := []struct {
+		text string
+		want string
+		end  protocol.UInteger
+	}{
+		{text: "- see [[proj|", want: "Project Plan]]", end: 12},
+		{text: "- see [[proj|]] later", want: "Project Plan", end: 12},
+	}
+	for _, test := range tests {
+		item, ok := complete(t, gi, "pages/edit.md", test.text)["Project Plan"]
+		if !ok {
+			t.Errorf("no item for %q", test.text)
+			continue
+		}
+		edit := item.TextEdit.(protocol.TextEdit)
+		want := protocol.Range{Start: protocol.Position{Character: 8}, End: protocol.Position{Character: test.end}}
+		if edit.NewText != test.want || edit.Range != want {
+			t.Errorf("edit of %q = %+v, want %q over %+v", test.text, edit, test.want, want)
+		}
+	}
+}
+
+func TestPropertyCompletion(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{
+		"pages/a.md": "type:: book\n\n- x\n  type:: book\n",
+		"pages/b.md": "type:: article\n",
+	})
+	config := gi.graphConfig()
+	config.SeparatedByCommas = []string{"related"}
+	gi.loaded.Store(&loadedGraph{config: config, index: gi.index()})
+
+	tests := []struct {
+		name   string
+		text   string
+		label  string
+		detail string
+		want   string
+		start  protocol.UInteger
+		absent string
+	}{
+		{name: "key followed by colons", text: "- ty::|", label: "type", detail: "used 3 times", want: "type:: ", start: 2},
+		{name: "key in front of colons", text: "ti|:: x", label: "title", detail: "built-in property", want: "title"},
+		{name: "used value", text: "type:: b|", label: "book", detail: "used 2 times", want: "book", start: 7, absent: "b"},
+		{name: "built-in value", text: "collapsed:: |", label: "false", detail: "built-in value", want: "false", start: 12},
+		{name: "page of a default list property", text: "tags:: x, b|", label: "b", want: "b", start: 10},
+		{name: "page of a configured list property", text: "- related:: [[a]],b|", label: "b", want: "b", start: 18},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			items := complete(t, gi, "pages/edit.md", test.text)
+			item, ok := items[test.label]
+			if !ok {
+				t.Fatalf("no item %q in %v", test.label, items)
+			}
+			if test.detail != "" && (item.Detail == nil || *item.Detail != test.detail) {
+				t.Errorf("detail = %v, want %q", item.Detail, test.detail)
+			}
+			edit := item.TextEdit.(protocol.TextEdit)
+			if edit.NewText != test.want || edit.Range.Start.Character != test.start {
+				t.Errorf("edit = %+v, want %q from %d", edit, test.want, test.start)
+			}
+			if _, ok := items[test.absent]; test.absent != "" && ok {
+				t.Errorf("completed %q, which is not a value of the property", test.absent)
+			}
+		})
+	}
+}
+
+func TestTagCompletion(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{
+		"pages/plain.md":     "- text\n",
+		"pages/What?.md":     "- text\n",
+		"pages/+1.md":        "- text\n",
+		"pages/two words.md": "- text\n",
+		"pages/refs.md":      "- [[plain]] #plain [[plan]]\n",
+	})
+	items := complete(t, gi, "pages/edit.md", "- see #|")
+	for label, want := range map[string]string{
+		"plain":     "#plain",
+		"plan":      "#plan",
+		"What?":     "#[[What?]]",
+		"+1":        "#[[+1]]",
+		"two words": "#[[two words]]",
+	} {
+		item, ok := items[label]
+		if !ok {
+			t.Errorf("no item %q", label)
+			continue
+		}
+		if edit := item.TextEdit.(protocol.TextEdit); edit.NewText != want {
+			t.Errorf("%s inserts %q, want %q", label, edit.NewText, want)
+		}
+		// what is inserted has to be read back as a tag of the same page
+		d, err := document.New(strings.NewReader("- " + want))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(d.Links) != 1 || d.Links[0].Type != document.Tag || d.Links[0].Target != label {
+			t.Errorf("%q is parsed as %+v, want a tag of %q", want, d.Links, label)
+		}
+	}
+
+	// plain is linked to twice and outranks the shorter plan, linked to once
+	items = complete(t, gi, "pages/edit.md", "- see #pla|")
+	if *items["plain"].SortText >= *items["plan"].SortText {
+		t.Errorf("plain sorts as %s after plan at %s", *items["plain"].SortText, *items["plan"].SortText)
+	}
+}
@@ -0,0 +1,362 @@
+package document
+
+import (
+	"errors"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"regexp"
+	"strings"
+)
+
+// Block is a single node of a page outline. A block starts at its "-" bullet and owns every following line up to the
+// next bullet. Lines before the first bullet form a pre-block that holds the page properties.
+type Block struct {
+	// Bullet is false for the pre-block
+	Bullet bool
+	// Level is the nesting depth of the block, top level blocks are 0
+	Level int
+	// Indent is the width of the whitespace in front of the bullet
+	Indent int
+	// Content holds the lines of the block with the bullet and indentation stripped
+	Content    []string
+	Properties []Property
+	Links      []Link
+	// Marker is the task marker of the block such as TODO or DONE, empty when the block is not a task
+	Marker      string
+	MarkerRange protocol.Range
+	// Priority is the A, B or C of a [#A] priority
+	Priority      string
+	PriorityRange protocol.Range
+	Scheduled     *Timestamp
+	Deadline      *Timestamp
+	Children      []*Block
+	Parent        *Block
+	// Range covers the lines of the block itself, not its children
+	Range protocol.Range
+	// Start and End are the byte offsets of Range in the document contents
+	Start int
+	End   int
+
+	contentColumn int
+}
+
+type Property struct {
+	// Key is lower case, as logseq compares keys
+	Key   string
+	Value string
+	// Values are the names of the pages the value refers to
+	Values []string
+	Range  protocol.Range
+}
+
+var ErrBlockNotFound = errors.New("block not found")
+
+var bulletRegex = regexp.MustCompile(`^([ \t]*)-(?:[ \t]+|$)`)
+var propertyRegex = regexp.MustCompile(`^[[:space:]]*(?:-[[:space:]]+)?([^[:space:]]+?)::(?:[[:space:]]+(.*?))?[[:space:]]*$`)
+
+// parse builds the block tree for the document and extracts the links of every block.
+func (d *Document) parse() {
+	var stack []*Block
+	var current *Block
+	// verbatim is the multi-line code or math region currently being scanned, its lines are never bullets or links
+	var verbatim *Region
+	// drawer tracks whether the line is inside an org :PROPERTIES: drawer
+	var drawer bool
+	offset := 0
+	for line, content := range strings.Split(d.Contents, "\n") {
+		if verbatim != nil {
+			current.Content = append(current.Content, trimIndent(content, current.contentColumn))
+			if verbatim.closes(content) {
+				d.closeRegion(verbatim, line, content, offset)
+				if verbatim.Type == AdvancedQuery {
+					d.addLinks(current, d.newAdvancedQueryLink(*verbatim))
+				}
+				verbatim = nil
+			}
+			d.extendBlock(current, line, content, offset)
+			offset += len(content) + 1
+			continue
+		}
+		indent, textStart, ok := d.blockStart(content)
+		if ok {
+			for len(stack) > 0 && stack[len(stack)-1].Indent >= indent {
+				stack = stack[:len(stack)-1]
+			}
+			current = &Block{
+				Bullet:        true,
+				Level:         len(stack),
+				Indent:        indent,
+				Start:         offset,
+				Range:         protocol.Range{Start: protocol.Position{Line: protocol.UInteger(line)}},
+				contentColumn: textStart,
+			}
+			if len(stack) > 0 {
+				current.Parent = stack[len(stack)-1]
+				current.Parent.Children = append(current.Parent.Children, current)
+			} else {
+				d.Blocks = append(d.Blocks, current)
+			}
+			stack = append(stack, current)
+			current.Content = append(current.Content, content[textStart:])
+			d.parseTask(current, line, content, textStart)
+			drawer = false
+		} else {
+			if current == nil {
+				if strings.TrimSpace(content) == "" {
+					offset += len(content) + 1
+					continue
+				}
+				current = &Block{
+					Start: offset,
+					Range: protocol.Range{Start: protocol.Position{Line: protocol.UInteger(line)}},
+				}
+				d.Blocks = append(d.Blocks, current)
+			}
+			current.Content = append(current.Content, trimIndent(content, current.contentColumn))
+		}
+		if verbatim = d.openRegion(line, content, textStart, offset); verbatim != nil {
+			masked := content[:verbatim.Start-offset] + strings.Repeat(" ", len(content)-(verbatim.Start-offset))
+			d.parseLine(current, line, content, masked, &drawer)
+		} else {
+			d.parseLine(current, line, content, d.inlineRegions(line, content, offset), &drawer)
+		}
+		d.extendBlock(current, line, content, offset)
+		offset += len(content) + 1
+	}
+	if verbatim != nil {
+		last := len(d.lines) - 1
+		d.closeRegion(verbatim, last, d.line(last), d.lines[last])
+		if verbatim.Type == AdvancedQuery {
+			d.addLinks(current, d.newAdvancedQueryLink(*verbatim))
+		}
+	}
+}
+
+// extendBlock grows the range of b to include the line.
+func (d *Document) extendBlock(b *Block, line int, content string, offset int) {
+	b.End = offset + len(content)
+	b.Range.End = protocol.Position{Line: protocol.UInteger(line), Character: d.Encoding.Column(content, len(content))}
+}
+
+// parseLine extracts the links and properties of a line. Matching runs against masked, a copy of content with code and
+// math blanked out, while ranges and targets are taken from content.
+func (d *Document) parseLine(b *Block, line int, content string, masked string, drawer *bool) {
+	d.parseTimestamps(b, line, content, masked)
+	var links []Link
+	//(0,1) start,end indexes of the regex match
+	//(2,3) start,end indexes of the first capture
+	//TODO order matters: make link regex not grab queries
+	for _, match := range queryLinkRegex.FindAllStringSubmatchIndex(masked, -1) {
+		href := content[match[2]:match[3]]
+		links = append(links, d.newLink(href, Query, line, content, match[2], match[3]))
+	}
+	links = append(links, d.parseMacros(line, content, masked)...)
+	labeled, refs := d.parseLabeledLinks(line, content, masked)
+	links = append(links, labeled...)
+	var tags []Link
+	// the [[link]] of a #[[tag]] is part of the tag, so tags are blanked out before page links are matched
+	untagged := []byte(refs)
+	for _, match := range tagLinkRegex.FindAllStringSubmatchIndex(refs, -1) {
+		if href, end := tagTarget(content, match); href != "" {
+			tags = append(tags, d.newLink(href, Tag, line, content, match[2], end))
+			for i := match[2]; i < end; i++ {
+				untagged[i] = ' '
+			}
+		}
+	}
+	if d.Format == Org {
+		for _, match := range orgLinkRegex.FindAllStringSubmatchIndex(string(untagged), -1) {
+			href := content[match[4]:match[5]]
+			link := d.newLink(href, Wiki, line, content, match[2], match[3])
+			if match[6] != -1 {
+				link.Label = content[match[6]:match[7]]
+			}
+			links = append(links, link)
+		}
+	} else {
+		for _, match := range wikiLinkRegex.FindAllStringSubmatchIndex(string(untagged), -1) {
+			href := content[match[4]:match[5]]
+			links = append(links, d.newLink(href, Wiki, line, content, match[2], match[3]))
+		}
+	}
+	links = append(links, tags...)
+	if match := d.matchProperty(b, masked, drawer); match != nil {
+		property := Property{
+			Key:   content[match[2]:match[3]],
+			Range: d.newRange(line, content, match[2], len(strings.TrimRight(content, " \t\r"))),
+		}
+		// logseq lowercases property keys in both formats
+		property.Key = strings.ToLower(property.Key)
+		links = append(links, d.newLink(property.Key, Prop, line, content, match[2], match[3]))
+		if match[4] != -1 {
+			property.Value = content[match[4]:match[5]]
+			//Value for id is technically a block embed link so we want to classify it as such
+			if property.Key != "id" {
+				links = append(links, d.parsePropertyValues(&property, line, content, match[4])...)
+			}
+		}
+		b.Properties = append(b.Properties, property)
+	}
+	for _, match := range embedLinkRegex.FindAllStringSubmatchIndex(refs, -1) {
+		href := content[match[2]:match[3]]
+		links = append(links, d.newLink(href, BlockEmbed, line, content, match[2], match[3]))
+	}
+
+	d.addLinks(b, links...)
+}
+
+// tagTarget returns the page a tagLinkRegex match refers to and the byte index the tag ends at.
+func tagTarget(content string, match []int) (string, int) {
+	if match[4] != -1 {
+		return strings.TrimSpace(content[match[4]:match[5]]), match[3]
+	}
+	name := strings.TrimRight(content[match[6]:match[7]], ".!?:")
+	return name, match[6] + len(name)
+}
+
+// addLinks attaches links to b and the document, dropping the empty links of unmatched captures.
+func (d *Document) addLinks(b *Block, links ...Link) {
+	for _, link := range links {
+		if link.Target == "" {
+			continue
+		}
+		b.Links = append(b.Links, link)
+		d.Links = append(d.Links, link)
+	}
+}
+
+// FindBlockForPosition returns the innermost block whose lines contain pos.
+func (d Document) FindBlockForPosition(pos protocol.Position) (*Block, error) {
+	blocks := d.Blocks
+	for len(blocks) > 0 {
+		var next []*Block
+		for _, b := range blocks {
+			if pos.Line >= b.Range.Start.Line && pos.Line <= b.Range.End.Line {
+				return b, nil
+			}
+			if pos.Line > b.Range.End.Line && pos.Line <= b.LastDescendant().Range.End.Line {
+				next = b.Children
+				break
+			}
+		}
+		blocks = next
+	}
+	return nil, ErrBlockNotFound
+}
+
+// Walk calls fn for every block of the document depth first, stopping early if fn returns false.
+func (d Document) Walk(fn func(b *Block) bool) {
+	for _, b := range d.Blocks {
+		if !b.walk(fn) {
+			return
+		}
+	}
+}
+
+func (b *Block) walk(fn func(b *Block) bool) bool {
+	if !fn(b) {
+		return false
+	}
+	for _, c := range b.Children {
+		if !c.walk(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// LastDescendant returns the deepest last child of the block or the block itself when it has no children.
+func (b *Block) LastDescendant() *Block {
+	for len(b.Children) > 0 {
+		b = b.Children[len(b.Children)-1]
+	}
+	return b
+}
+
+// TreeRange returns the range of the block including all of its children.
+func (b *Block) TreeRange() protocol.Range {
+	return protocol.Range{Start: b.Range.Start, End: b.LastDescendant().Range.End}
+}
+
+// Property returns the value of the property with the given key, ignoring case.
+func (b *Block) Property(key string) (string, bool) {
+	key = strings.ToLower(key)
+	for _, p := range b.Properties {
+		if p.Key == key {
+			return p.Value, true
+		}
+	}
+	return "", false
+}
+
+// Text returns the lines of the block without its property lines.
+func (b *Block) Text() []string {
+	var text []string
+	for _, line := range b.Content {
+		isProperty := false
+		for _, p := range b.Properties {
+			if strings.HasPrefix(strings.ToLower(strings.TrimSpace(line)), p.Key+"::") {
+				isProperty = true
+				break
+			}
+		}
+		if !isProperty {
+			text = append(text, line)
+		}
+	}
+	return text
+}
+
+// IDEdit returns the edit that gives the block an id property, written after the first line of the block the way
+// logseq writes it when the block is first referenced. Org blocks get the property in their :PROPERTIES: drawer,
+// which is created when the block has none.
+func (d Document) IDEdit(b *Block, id string) protocol.TextEdit {
+	line := int(b.Range.Start.Line)
+	newline := "\n"
+	if strings.HasSuffix(d.line(line), "\r") {
+		newline = "\r\n"
+	}
+	text := newline + LeadingSpace(d.line(line)) + "  id:: " + id
+	if d.Format == Org {
+		text = newline + ":PROPERTIES:" + newline + ":id: " + id + newline + ":END:"
+		for i, content := range b.Content {
+			if strings.EqualFold(strings.TrimSpace(content), ":PROPERTIES:") {
+				line, text = line+i, newline+":id: "+id
+				break
+			}
+		}
+	}
+	end := d.lineEnd(line)
+	return protocol.TextEdit{Range: protocol.Range{Start: end, End: end}, NewText: text}
+}
+
+// lineEnd returns the position at the end of a line, before its line break.
+func (d Document) lineEnd(line int) protocol.Position {
+	content := strings.TrimSuffix(d.line(line), "\r")
+	return protocol.Position{Line: protocol.UInteger(line), Character: d.Encoding.Column(content, len(content))}
+}
+
+// LeadingSpace returns the spaces and tabs s starts with.
+func LeadingSpace(s string) string {
+	return s[:len(s)-len(strings.TrimLeft(s, " \t"))]
+}
+
+func indentWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		if r == '\t' {
+			width += 2
+		} else {
+			width++
+		}
+	}
+	return width
+}
+
+// trimIndent strips up to column bytes of leading whitespace from a continuation line.
+func trimIndent(content string, column int) string {
+	i := 0
+	for i < len(content) && i < column && (content[i] == ' ' || content[i] == '\t') {
+		i++
+	}
+	return content[i:]
+}
@@ -5,18 +5,43 @@ import (
 	protocol "github.com/tliron/glsp/protocol_3_16"
 	"io"
 	"regexp"
-	"strings"
 )
 
 type Document struct {
 	Contents string
 	Links    []Link
+	// Blocks holds the top level blocks of the page outline
+	Blocks []*Block
+	// Regions holds the code and math spans of the document
+	Regions []Region
+	// Encoding is the unit every Range in the document is expressed in
+	Encoding PositionEncoding
+	Format   Format
+
+	lines []int
+	// separatedByCommas holds the property keys whose plain comma separated values are pages
+	separatedByCommas map[string]bool
+}
+
+type Option func(d *Document)
+
+// WithEncoding sets the position encoding negotiated with the client, UTF16 is used by default.
+func WithEncoding(e PositionEncoding) Option {
+	return func(d *Document) {
+		d.Encoding = e
+	}
 }
 
 type Link struct {
 	Target string
 	Range  protocol.Range
 	Type   linkType
+	// Label is the text shown for a [label](target) link
+	Label string
+	// Args are the comma separated arguments of a Macro link
+	Args []string
+	// Datalog holds the parts of an advanced query, it is only set for Query links read from a #+BEGIN_QUERY block
+	Datalog *DatalogQuery
 }
 
 type linkType string
@@ -28,98 +53,75 @@ var (
 	PropValue  linkType = "PROPVALUE"
 	BlockEmbed linkType = "EMBED"
 	Query      linkType = "QUERY"
+	// Asset links point at a file such as ../assets/image.png, relative to the pages directory
+	Asset    linkType = "ASSET"
+	External linkType = "EXTERNAL"
+	// Macro links are {{name args}} calls of built-in or config.edn macros, the target is the macro name
+	Macro linkType = "MACRO"
 )
 
 var ErrLinkNotFound = errors.New("link not found")
 
 var wikiLinkRegex = regexp.MustCompile(`(?:{{embed )?(\[*\[\[(.+?)]])`)
-var queryLinkRegex = regexp.MustCompile(`{{query (.*)`)
-var tagLinkRegex = regexp.MustCompile(`#([[:graph:]]+)[[:space:]]?`)
-var propertyLinkRegex = regexp.MustCompile(`^[[:space:]]*-?[[:space:]]*((.*)::[[:space:]]*(.*))$`)
+var queryLinkRegex = regexp.MustCompile(`{{query[ \t]+(.*?)(?:}}|$)`)
+
+// tagLinkRegex matches #tag and #[[multi word tag]] when the # starts a word, so url fragments, [#A] priorities and
+// headings are not tags, nor are org keywords such as #+BEGIN_QUOTE. Trailing sentence punctuation is trimmed from plain tags by tagTarget.
+var tagLinkRegex = regexp.MustCompile(`(?:^|[[:space:]])(#(?:\[\[([^\]]+)]]|([^[:space:],;"'()\[\]{}#+][^[:space:],;"'()\[\]{}#]*)))`)
 var embedLinkRegex = regexp.MustCompile(`.*\(?\(?([a-fA-F0-9]{8}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{4}-[a-fA-F0-9]{12})\)?\)?.*`)
 
-// TODO add a document cache and update it on writes to avoid re-reading files every time an event happens
+// IsPlainTag reports whether name can be written as a plain #tag that reads back as the same page. Other names have to
+// be written as #[[name]].
+func IsPlainTag(name string) bool {
+	content := "#" + name
+	match := tagLinkRegex.FindStringSubmatchIndex(content)
+	if match == nil || match[2] != 0 || match[3] != len(content) || match[6] == -1 {
+		return false
+	}
+	target, end := tagTarget(content, match)
+	return target == name && end == len(content)
+}
+
 // TODO resolve all link uris at document load time to avoid re-querying the ls api
-func New(reader io.Reader) (Document, error) {
+func New(reader io.Reader, options ...Option) (Document, error) {
 	file, err := io.ReadAll(reader)
 	if err != nil {
 		return Document{}, err
 	}
 
-	var links []Link
-	for line, content := range strings.Split(string(file), "\n") {
-
-		//(0,1) start,end indexes of the regex match
-		//(2,3) start,end indexes of the first capture
-		//TODO order matters: make link regex not grab queries
-		for _, match := range queryLinkRegex.FindAllStringSubmatchIndex(content, -1) {
-			href := content[match[2]:match[3]]
-			links = append(links, newLink(href, Query, line, match[2], match[3]))
-		}
-		for _, match := range wikiLinkRegex.FindAllStringSubmatchIndex(content, -1) {
-			href := content[match[4]:match[5]]
-			links = append(links, newLink(href, Wiki, line, match[2], match[3]))
-		}
-		for _, match := range tagLinkRegex.FindAllStringSubmatchIndex(content, -1) {
-			href := content[match[2]:match[3]]
-			links = append(links, newLink(href, Tag, line, match[0], match[1]))
-		}
-		for _, match := range propertyLinkRegex.FindAllStringSubmatchIndex(content, -1) {
-			href := content[match[4]:match[5]]
-
-			links = append(links, newLink(href, Prop, line, match[4], match[5]))
-
-			//Value for id is technically a block embed link so we want to classify it as such
-			if href != "id" {
-				links = append(links, newLink(content[match[6]:match[7]], PropValue, line, match[6], match[7]))
-			}
-
-		}
-		for _, match := range embedLinkRegex.FindAllStringSubmatchIndex(content, -1) {
-			href := content[match[2]:match[3]]
-			links = append(links, newLink(href, BlockEmbed, line, match[2], match[3]))
-		}
-
+	d := Document{Encoding: UTF16, Format: Markdown, separatedByCommas: map[string]bool{}}
+	WithSeparatedByCommas(DefaultSeparatedByCommas...)(&d)
+	for _, option := range options {
+		option(&d)
 	}
-	return Document{Contents: string(file), Links: links}, nil
+	d.load(string(file))
+	return d, nil
+}
+
+func (d *Document) load(contents string) {
+	d.Links, d.Blocks, d.Regions = nil, nil, nil
+	d.Contents = contents
+	d.lines = lineOffsets(contents)
+	d.parse()
 }
 
 func (d Document) FindLinkForPosition(pos protocol.Position) (Link, error) {
 	for _, link := range d.Links {
-		if positionInRange(d.Contents, link.Range, pos) {
+		if d.RangeContains(link.Range, pos) {
 			return link, nil
 		}
 	}
 	return Link{}, ErrLinkNotFound
 }
 
-func newLink(href string, t linkType, line, start, end int) Link {
+// newLink builds a link from byte indexes into the line content, converting them to the document's encoding.
+func (d Document) newLink(href string, t linkType, line int, content string, start, end int) Link {
 	if href == "" {
 		return Link{}
 	}
-
-	//// Go regexes work with bytes, but the LSP client expects character indexes.
-	//start = strutil.ByteIndexToRuneIndex(line, start)
-	//end = strutil.ByteIndexToRuneIndex(line, end)
 	return Link{
 		Target: href,
 		Type:   t,
-		Range: protocol.Range{
-			Start: protocol.Position{
-				Line:      protocol.UInteger(line),
-				Character: protocol.UInteger(start),
-			},
-			End: protocol.Position{
-				Line:      protocol.UInteger(line),
-				Character: protocol.UInteger(end),
-			},
-		},
+		Range:  d.newRange(line, content, start, end),
 	}
 }
-
-func positionInRange(content string, rng protocol.Range, pos protocol.Position) bool {
-	start, end := rng.IndexesIn(content)
-	i := pos.IndexIn(content)
-	//logger.Info("indexes", slog.Int("start", start), slog.Int("end", end), slog.Int("i", i))
-	return i >= start && i <= end
-}
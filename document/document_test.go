@@ -0,0 +1,534 @@
+package document
+
+import (
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+	"testing"
+)
+
+func TestLinkRangesMultiByte(t *testing.T) {
+	contents := "- café [[Crème brûlée]] 🍮 [[日本語]]\n  tags:: 😀 [[x]]"
+	tests := []struct {
+		name     string
+		encoding PositionEncoding
+		want     map[string]protocol.Range
+	}{
+		{
+			name:     "utf-16",
+			encoding: UTF16,
+			want: map[string]protocol.Range{
+				"Crème brûlée": {Start: protocol.Position{Line: 0, Character: 7}, End: protocol.Position{Line: 0, Character: 23}},
+				"日本語":          {Start: protocol.Position{Line: 0, Character: 27}, End: protocol.Position{Line: 0, Character: 34}},
+				"x":            {Start: protocol.Position{Line: 1, Character: 12}, End: protocol.Position{Line: 1, Character: 17}},
+			},
+		},
+		{
+			name:     "utf-32",
+			encoding: UTF32,
+			want: map[string]protocol.Range{
+				"Crème brûlée": {Start: protocol.Position{Line: 0, Character: 7}, End: protocol.Position{Line: 0, Character: 23}},
+				"日本語":          {Start: protocol.Position{Line: 0, Character: 26}, End: protocol.Position{Line: 0, Character: 33}},
+				"x":            {Start: protocol.Position{Line: 1, Character: 11}, End: protocol.Position{Line: 1, Character: 16}},
+			},
+		},
+		{
+			name:     "utf-8",
+			encoding: UTF8,
+			want: map[string]protocol.Range{
+				"Crème brûlée": {Start: protocol.Position{Line: 0, Character: 8}, End: protocol.Position{Line: 0, Character: 27}},
+				"日本語":          {Start: protocol.Position{Line: 0, Character: 33}, End: protocol.Position{Line: 0, Character: 46}},
+				"x":            {Start: protocol.Position{Line: 1, Character: 14}, End: protocol.Position{Line: 1, Character: 19}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := New(strings.NewReader(contents), WithEncoding(tt.encoding))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for target, want := range tt.want {
+				var found bool
+				for _, l := range d.Links {
+					if l.Target != target {
+						continue
+					}
+					found = true
+					if l.Range != want {
+						t.Errorf("range for %q = %v, want %v", target, l.Range, want)
+					}
+					got, err := d.FindLinkForPosition(want.End)
+					if err != nil || got.Target != target {
+						t.Errorf("FindLinkForPosition(%v) = %q, %v, want %q", want.End, got.Target, err, target)
+					}
+				}
+				if !found {
+					t.Errorf("no link for %q", target)
+				}
+			}
+		})
+	}
+}
+
+func TestOffsetPositionRoundTrip(t *testing.T) {
+	contents := "- 🍮 a\n- ü\n\n- 日本"
+	for _, e := range []PositionEncoding{UTF8, UTF16, UTF32} {
+		d, err := New(strings.NewReader(contents), WithEncoding(e))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range contents {
+			if got := d.Offset(d.Position(i)); got != i {
+				t.Errorf("%s: Offset(Position(%d)) = %d", e, i, got)
+			}
+		}
+	}
+}
+
+func TestParsePositionEncoding(t *testing.T) {
+	tests := []struct {
+		offered []string
+		want    PositionEncoding
+	}{
+		{nil, UTF16},
+		{[]string{"utf-32", "utf-16"}, UTF32},
+		{[]string{"utf-7", "utf-8"}, UTF8},
+	}
+	for _, tt := range tests {
+		if got := ParsePositionEncoding(tt.offered); got != tt.want {
+			t.Errorf("ParsePositionEncoding(%v) = %s, want %s", tt.offered, got, tt.want)
+		}
+	}
+}
+
+func TestVerbatimRegionsHaveNoLinks(t *testing.T) {
+	contents := "- [[a]] `[[b]] #c` $$[[d]]$$\n- ```go\n  #include [[e]]\n  - 63c5db9e-768b-4d81-965e-240b4f69e4e0\n  ```\n\t- [[f]]\n- #+BEGIN_SRC clojure\n  [[g]]\n  #+END_SRC\n- [[h]]"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var targets []string
+	for _, l := range d.Links {
+		targets = append(targets, l.Target)
+	}
+	if got, want := strings.Join(targets, ","), "a,f,h"; got != want {
+		t.Errorf("link targets = %s, want %s", got, want)
+	}
+	var types []string
+	for _, r := range d.Regions {
+		types = append(types, string(r.Type))
+	}
+	if got, want := strings.Join(types, ","), "INLINECODE,MATH,CODEFENCE,SOURCE"; got != want {
+		t.Errorf("region types = %s, want %s", got, want)
+	}
+	if len(d.Blocks) != 4 || len(d.Blocks[1].Children) != 1 {
+		t.Errorf("fenced bullet was parsed as a block: %d top level blocks", len(d.Blocks))
+	}
+}
+
+func TestOrgOutline(t *testing.T) {
+	contents := "#+TITLE: Org Page\n#+alias: op\n\n* TODO heading [[a]] =[[not]]=\n:PROPERTIES:\n:id: 63c5db9e-768b-4d81-965e-240b4f69e4e0\n:type: [[b]]\n:END:\n** child [[c][label]] [[https://example.com][site]]\n* sibling #d\n#+BEGIN_SRC go\n[[e]]\n#+END_SRC\n"
+	d, err := New(strings.NewReader(contents), WithFormat(Org))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Blocks) != 3 {
+		t.Fatalf("got %d top level blocks, want 3", len(d.Blocks))
+	}
+	if v, ok := d.Blocks[0].Property("title"); !ok || v != "Org Page" {
+		t.Errorf("title property = %q, %v", v, ok)
+	}
+	heading := d.Blocks[1]
+	if len(heading.Children) != 1 || heading.Children[0].Content[0] != "child [[c][label]] [[https://example.com][site]]" {
+		t.Errorf("unexpected children %v", heading.Children)
+	}
+	if v, _ := heading.Property("id"); v != "63c5db9e-768b-4d81-965e-240b4f69e4e0" {
+		t.Errorf("id property = %q", v)
+	}
+	var targets []string
+	for _, l := range d.Links {
+		if l.Type == Wiki || l.Type == Tag {
+			targets = append(targets, l.Target)
+		}
+	}
+	if got, want := strings.Join(targets, ","), "a,b,c,d"; got != want {
+		t.Errorf("link targets = %s, want %s", got, want)
+	}
+}
+
+func TestTaskFields(t *testing.T) {
+	contents := "- DOING [#b] write parser\n  SCHEDULED: <2023-01-20 Fri 10:30 .+1d>\n  DEADLINE: <2023-02-01 Wed>\n- TODOS are not tasks\n"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := d.Blocks[0]
+	if task.Marker != "DOING" || task.Priority != "B" {
+		t.Errorf("marker, priority = %q, %q", task.Marker, task.Priority)
+	}
+	if task.Scheduled == nil || !task.Scheduled.HasTime || task.Scheduled.Repeater != ".+1d" || task.Scheduled.Date.Format("2006-01-02 15:04") != "2023-01-20 10:30" {
+		t.Errorf("scheduled = %+v", task.Scheduled)
+	}
+	if task.Deadline == nil || task.Deadline.HasTime || task.Deadline.Date.Format("2006-01-02") != "2023-02-01" {
+		t.Errorf("deadline = %+v", task.Deadline)
+	}
+	if d.Blocks[1].Marker != "" {
+		t.Errorf("marker = %q, want none", d.Blocks[1].Marker)
+	}
+}
+
+func TestPropertyValues(t *testing.T) {
+	contents := "- block\n  tags:: a, [[b, c]], #d, \"e, f\"\n  type:: book, [[g]]\n  area:: x, y"
+	d, err := New(strings.NewReader(contents), WithSeparatedByCommas("area"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"tags": "a|b, c|d", "type": "g", "area": "x|y"}
+	for _, p := range d.Blocks[0].Properties {
+		if got := strings.Join(p.Values, "|"); got != want[p.Key] {
+			t.Errorf("%s values = %s, want %s", p.Key, got, want[p.Key])
+		}
+	}
+	var ranges []string
+	for _, l := range d.Links {
+		if l.Type == PropValue {
+			start, end := d.Offset(l.Range.Start), d.Offset(l.Range.End)
+			ranges = append(ranges, d.Contents[start:end])
+		}
+	}
+	if got, want := strings.Join(ranges, "|"), "a|x|y"; got != want {
+		t.Errorf("property value links = %s, want %s", got, want)
+	}
+
+	// keys are matched against the separated-by-commas properties regardless of case
+	d, err = New(strings.NewReader("- block\n  Tags:: a, b\n  AREA:: x\n"), WithSeparatedByCommas("Area"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, p := range d.Blocks[0].Properties {
+		values = append(values, p.Values...)
+	}
+	if got, want := strings.Join(values, "|"), "a|b|x"; got != want {
+		t.Errorf("values of capitalized keys = %s, want %s", got, want)
+	}
+}
+
+func TestQueryLinks(t *testing.T) {
+	contents := "- {{query (todo now)}} after [[x]]\n- #+BEGIN_QUERY\n  {:title \"Todos\"\n   :query [:find (pull ?b [*]) :where [?b :block/marker ?m]]\n   :inputs [:today]}\n  #+END_QUERY\n- [[y]]"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var queries []Link
+	for _, l := range d.Links {
+		if l.Type == Query {
+			queries = append(queries, l)
+		}
+	}
+	if len(queries) != 2 {
+		t.Fatalf("got %d query links, want 2", len(queries))
+	}
+	if queries[0].Target != "(todo now)" {
+		t.Errorf("simple query target = %q", queries[0].Target)
+	}
+	advanced := queries[1]
+	if advanced.Range.Start.Line != 1 || advanced.Range.End.Line != 5 {
+		t.Errorf("advanced query range = %v", advanced.Range)
+	}
+	if advanced.Datalog == nil {
+		t.Fatal("advanced query was not parsed")
+	}
+	if advanced.Datalog.Title != "Todos" || advanced.Datalog.Query != "[:find (pull ?b [*]) :where [?b :block/marker ?m]]" || strings.Join(advanced.Datalog.Inputs, ",") != ":today" {
+		t.Errorf("datalog = %+v", advanced.Datalog)
+	}
+	if l, err := d.FindLinkForPosition(protocol.Position{Line: 3, Character: 5}); err != nil || l.Datalog == nil {
+		t.Errorf("FindLinkForPosition inside the query = %v, %v", l, err)
+	}
+}
+
+func TestLabeledLinks(t *testing.T) {
+	contents := "- [see]([[Some Page]]) [ref](((63c5db9e-768b-4d81-965e-240b4f69e4e0))) [site](https://example.com/a#b)\n- ![img](../assets/foo.png) bare https://logseq.com/docs. done"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range d.Links {
+		start, end := d.Offset(l.Range.Start), d.Offset(l.Range.End)
+		got = append(got, string(l.Type)+":"+l.Target+":"+l.Label+":"+d.Contents[start:end])
+	}
+	want := []string{
+		"WIKI:Some Page:see:[see]([[Some Page]])",
+		"EMBED:63c5db9e-768b-4d81-965e-240b4f69e4e0:ref:[ref](((63c5db9e-768b-4d81-965e-240b4f69e4e0)))",
+		"EXTERNAL:https://example.com/a#b:site:[site](https://example.com/a#b)",
+		"ASSET:../assets/foo.png:img:![img](../assets/foo.png)",
+		"EXTERNAL:https://logseq.com/docs::https://logseq.com/docs",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("links =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTagGrammar(t *testing.T) {
+	contents := "# Heading\n- #tag, #[[multi word]] and #end. [#A] https://example.com/#frag a#b #ns/child #日本語!\n- #+BEGIN_QUOTE"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range d.Links {
+		if l.Type != Tag {
+			continue
+		}
+		start, end := d.Offset(l.Range.Start), d.Offset(l.Range.End)
+		got = append(got, l.Target+"="+d.Contents[start:end])
+	}
+	want := "tag=#tag|multi word=#[[multi word]]|end=#end|ns/child=#ns/child|日本語=#日本語"
+	if strings.Join(got, "|") != want {
+		t.Errorf("tags = %s, want %s", strings.Join(got, "|"), want)
+	}
+}
+
+func TestBracketTagIsNotAWikiLink(t *testing.T) {
+	for _, format := range []Format{Markdown, Org} {
+		d, err := New(strings.NewReader("- #[[multi word]] [[page]]\n"), WithFormat(format))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, l := range d.Links {
+			got = append(got, string(l.Type)+"="+l.Target)
+		}
+		if want := "WIKI=page,TAG=multi word"; strings.Join(got, ",") != want {
+			t.Errorf("%s links = %s, want %s", format, strings.Join(got, ","), want)
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	contents := "- {{video https://youtu.be/x}} {{cloze a, [[b, c]]}} {{query (todo now)}}\n- {{poem red, blue}} {{missing}} {{video}}"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range d.Links {
+		if l.Type == Macro {
+			got = append(got, l.Target+"("+strings.Join(l.Args, "|")+")")
+		}
+	}
+	want := "video(https://youtu.be/x) cloze(a|[[b, c]]) poem(red|blue) missing() video()"
+	if strings.Join(got, " ") != want {
+		t.Errorf("macros = %s, want %s", strings.Join(got, " "), want)
+	}
+	macros := map[string]string{"poem": "Rose is $1, violet's $2"}
+	if expanded := ExpandMacro(macros["poem"], []string{"red", "blue"}); expanded != "Rose is red, violet's blue" {
+		t.Errorf("expanded = %s", expanded)
+	}
+	var messages []string
+	for _, diagnostic := range d.MacroDiagnostics(macros) {
+		messages = append(messages, diagnostic.Message)
+	}
+	wantMessages := "unknown macro missing|macro video expects 1 arguments, got 0"
+	if strings.Join(messages, "|") != wantMessages {
+		t.Errorf("diagnostics = %s, want %s", strings.Join(messages, "|"), wantMessages)
+	}
+}
+
+func TestNamespaceAt(t *testing.T) {
+	contents := "- [[project/backend/api]] #ns/tag"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wiki, tag := d.Links[0], d.Links[1]
+	for character, want := range map[protocol.UInteger]string{
+		2:  "project/backend/api",
+		4:  "project",
+		11: "project",
+		12: "project/backend",
+		20: "project/backend/api",
+		24: "project/backend/api",
+	} {
+		if got := d.NamespaceAt(wiki, protocol.Position{Character: character}); got != want {
+			t.Errorf("NamespaceAt(%d) = %q, want %q", character, got, want)
+		}
+	}
+	if got := d.NamespaceAt(tag, protocol.Position{Character: 28}); got != "ns" {
+		t.Errorf("NamespaceAt(tag) = %q, want ns", got)
+	}
+	if NamespaceParent("a/b/c") != "a/b" || NamespaceParent("a") != "" {
+		t.Error("NamespaceParent")
+	}
+}
+
+func TestIDEdit(t *testing.T) {
+	const id = "63c5db9e-768b-4d81-965e-240b4f69e4e0"
+	tests := []struct {
+		name     string
+		contents string
+		format   Format
+		want     string
+	}{
+		{"markdown", "- parent\n\t- child\n\t  tags:: a\n", Markdown, "- parent\n\t- child\n\t  id:: " + id + "\n\t  tags:: a\n"},
+		{"crlf", "- block\r\n", Markdown, "- block\r\n  id:: " + id + "\r\n"},
+		{"org", "* heading\n** child\n", Org, "* heading\n** child\n:PROPERTIES:\n:id: " + id + "\n:END:\n"},
+		{"org drawer", "* heading\n** child\n:PROPERTIES:\n:type: a\n:END:\n", Org, "* heading\n** child\n:PROPERTIES:\n:id: " + id + "\n:type: a\n:END:\n"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d, err := New(strings.NewReader(test.contents), WithFormat(test.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			b := d.Blocks[len(d.Blocks)-1].LastDescendant()
+			edit := d.IDEdit(b, id)
+			if got := d.Edit(&edit.Range, edit.NewText).Contents; got != test.want {
+				t.Errorf("got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMarkerEdit(t *testing.T) {
+	d, err := New(strings.NewReader("- buy milk\n\t- TODO call\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, b := range []*Block{d.Blocks[0], d.Blocks[0].Children[0]} {
+		edit := d.MarkerEdit(b, "DOING")
+		d = d.Edit(&edit.Range, edit.NewText)
+	}
+	if want := "- DOING buy milk\n\t- DOING call\n"; d.Contents != want {
+		t.Errorf("got %q, want %q", d.Contents, want)
+	}
+}
+
+func TestBlockTree(t *testing.T) {
+	contents := "title:: Page\n\n- one [[a]]\n  continued [[b]]\n  - child\n    - grandchild\n      grandchild text\n\t- tab child\n- two\n"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Blocks) != 3 {
+		t.Fatalf("got %d top level blocks, want 3", len(d.Blocks))
+	}
+	pre, one, two := d.Blocks[0], d.Blocks[1], d.Blocks[2]
+	if pre.Bullet || pre.Range.Start.Line != 0 || pre.Range.End.Line != 1 {
+		t.Errorf("pre-block = %+v", pre)
+	}
+	if v, ok := pre.Property("title"); !ok || v != "Page" {
+		t.Errorf("title property = %q, %v", v, ok)
+	}
+	if got, want := strings.Join(one.Content, "|"), "one [[a]]|continued [[b]]"; got != want {
+		t.Errorf("content = %q, want %q", got, want)
+	}
+	if len(one.Links) != 2 || one.Links[1].Target != "b" {
+		t.Errorf("links of a continuation line are not part of the block: %+v", one.Links)
+	}
+	if one.Range.Start.Line != 2 || one.Range.End.Line != 3 {
+		t.Errorf("range = %+v, want lines 2 to 3", one.Range)
+	}
+	if len(one.Children) != 2 {
+		t.Fatalf("got %d children, want 2", len(one.Children))
+	}
+	child, tab := one.Children[0], one.Children[1]
+	if child.Level != 1 || child.Parent != one || tab.Level != 1 || tab.Content[0] != "tab child" {
+		t.Errorf("children = %+v, %+v", child, tab)
+	}
+	if len(child.Children) != 1 {
+		t.Fatalf("got %d grandchildren, want 1", len(child.Children))
+	}
+	grandchild := child.Children[0]
+	if grandchild.Level != 2 || strings.Join(grandchild.Content, "|") != "grandchild|grandchild text" {
+		t.Errorf("grandchild = %+v", grandchild)
+	}
+	if one.LastDescendant() != tab || child.LastDescendant() != grandchild {
+		t.Error("unexpected last descendants")
+	}
+	want := protocol.Range{Start: protocol.Position{Line: 2}, End: protocol.Position{Line: 7, Character: 12}}
+	if got := one.TreeRange(); got != want {
+		t.Errorf("tree range = %+v, want %+v", got, want)
+	}
+	if got := grandchild.TreeRange(); got != grandchild.Range {
+		t.Errorf("tree range of a leaf = %+v, want its range %+v", got, grandchild.Range)
+	}
+	if len(two.Children) != 0 || two.Level != 0 || contents[two.Start:two.End] != "- two\n" {
+		t.Errorf("last block = %+v, %q", two, contents[two.Start:two.End])
+	}
+}
+
+func TestFindBlockForPosition(t *testing.T) {
+	contents := "title:: Page\n\n- one\n  continued\n  - child\n    - grandchild\n  - second child\n- two\n"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for line, want := range map[protocol.UInteger]string{
+		0: "title:: Page",
+		1: "title:: Page",
+		2: "one",
+		3: "one",
+		4: "child",
+		5: "grandchild",
+		6: "second child",
+		7: "two",
+	} {
+		b, err := d.FindBlockForPosition(protocol.Position{Line: line, Character: 1})
+		if err != nil {
+			t.Errorf("line %d: %v", line, err)
+			continue
+		}
+		if b.Content[0] != want {
+			t.Errorf("line %d is in block %q, want %q", line, b.Content[0], want)
+		}
+	}
+	if _, err := d.FindBlockForPosition(protocol.Position{Line: 20}); err != ErrBlockNotFound {
+		t.Errorf("position past the end: err = %v, want ErrBlockNotFound", err)
+	}
+}
+
+func TestInCode(t *testing.T) {
+	contents := "- `code` text\n- ```\n  fenced\n  ```\n- #+BEGIN_SRC go\n  source\n  #+END_SRC\n- $$math$$"
+	d, err := New(strings.NewReader(contents))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		pos  protocol.Position
+		want bool
+	}{
+		{protocol.Position{Line: 0, Character: 2}, false},
+		{protocol.Position{Line: 0, Character: 4}, true},
+		{protocol.Position{Line: 0, Character: 8}, false},
+		{protocol.Position{Line: 1, Character: 5}, true},
+		{protocol.Position{Line: 2, Character: 4}, true},
+		{protocol.Position{Line: 5, Character: 2}, true},
+		{protocol.Position{Line: 7, Character: 5}, false},
+	} {
+		if got := d.InCode(tt.pos); got != tt.want {
+			t.Errorf("InCode(%d:%d) = %v, want %v", tt.pos.Line, tt.pos.Character, got, tt.want)
+		}
+	}
+}
+
+func TestIsPlainTag(t *testing.T) {
+	for name, want := range map[string]bool{
+		"tag":        true,
+		"ns/child":   true,
+		"日本語":        true,
+		"What?":      false,
+		"end.":       false,
+		"+1":         false,
+		"two words":  false,
+		"a,b":        false,
+		"[[x]]":      false,
+		"":           false,
+		"semi;colon": false,
+	} {
+		if got := IsPlainTag(name); got != want {
+			t.Errorf("IsPlainTag(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
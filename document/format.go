@@ -0,0 +1,103 @@
+package document
+
+import (
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Format is the markup language of a graph file, matching the values of Block.Format in the logseq api.
+type Format string
+
+const (
+	Markdown Format = "markdown"
+	Org      Format = "org"
+)
+
+// FormatForPath picks the format of a graph file from its extension.
+func FormatForPath(p string) Format {
+	if strings.EqualFold(path.Ext(p), ".org") {
+		return Org
+	}
+	return Markdown
+}
+
+// Extension returns the file extension, without the dot, used for pages in the format.
+func (f Format) Extension() string {
+	if f == Org {
+		return "org"
+	}
+	return "md"
+}
+
+// WithFormat sets the markup language of the document, Markdown is used by default.
+func WithFormat(f Format) Option {
+	return func(d *Document) {
+		d.Format = f
+	}
+}
+
+var orgHeadingRegex = regexp.MustCompile(`^(\*+)(?:[ \t]+|$)`)
+var orgPropertyRegex = regexp.MustCompile(`^[[:space:]]*:([^[:space:]:]+):(?:[[:space:]]+(.*?))?[[:space:]]*$`)
+var orgPagePropertyRegex = regexp.MustCompile(`^#\+([[:alnum:]_-]+):(?:[[:space:]]+(.*?))?[[:space:]]*$`)
+var orgLinkRegex = regexp.MustCompile(`(?:{{embed )?(\[\[([^\]]+)](?:\[([^\]]*)])?])`)
+var orgVerbatimRegex = regexp.MustCompile(`(?:^|[[:space:]({'"])(=[^[:space:]=](?:[^=]*?[^[:space:]=])?=|~[^[:space:]~](?:[^~]*?[^[:space:]~])?~)`)
+
+// blockStart reports whether a line begins a new block, returning the indentation of the block and the byte index its
+// text starts at.
+func (d *Document) blockStart(content string) (indent int, textStart int, ok bool) {
+	if d.Format == Org {
+		match := orgHeadingRegex.FindStringSubmatchIndex(content)
+		if match == nil {
+			return 0, 0, false
+		}
+		return match[3] - match[2], match[1], true
+	}
+	match := bulletRegex.FindStringSubmatchIndex(content)
+	if match == nil {
+		return 0, 0, false
+	}
+	return indentWidth(content[match[2]:match[3]]), match[1], true
+}
+
+// matchProperty returns the submatch indexes of the property key and value on a line, or nil when the line is not a
+// property. Org properties only exist inside a :PROPERTIES: drawer, whose state is tracked in drawer, or as #+key:
+// page properties before the first heading.
+func (d *Document) matchProperty(b *Block, masked string, drawer *bool) []int {
+	if d.Format != Org {
+		return propertyRegex.FindStringSubmatchIndex(masked)
+	}
+	switch strings.ToUpper(strings.TrimSpace(masked)) {
+	case ":PROPERTIES:":
+		*drawer = true
+		return nil
+	case ":END:":
+		*drawer = false
+		return nil
+	}
+	if *drawer {
+		return orgPropertyRegex.FindStringSubmatchIndex(masked)
+	}
+	if !b.Bullet {
+		return orgPagePropertyRegex.FindStringSubmatchIndex(masked)
+	}
+	return nil
+}
+
+// isOrgPageTarget reports whether an org link target names a page rather than a file or url.
+func isOrgPageTarget(target string) bool {
+	return !strings.HasPrefix(target, "file:") && !strings.Contains(target, "://")
+}
+
+// orgVerbatim finds the =verbatim= and ~code~ spans of an org line.
+func orgVerbatim(content string) [][2]int {
+	var spans [][2]int
+	for _, match := range orgVerbatimRegex.FindAllStringSubmatchIndex(content, -1) {
+		end := match[3]
+		if end < len(content) && !strings.ContainsRune(" \t.,;:!?')}\"", rune(content[end])) {
+			continue
+		}
+		spans = append(spans, [2]int{match[2], end})
+	}
+	return spans
+}
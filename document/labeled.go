@@ -0,0 +1,65 @@
+package document
+
+import (
+	"regexp"
+	"strings"
+)
+
+var labeledLinkRegex = regexp.MustCompile(`(!)?\[([^\[\]]*)]\((\[\[[^\]]+]]|\(\([^)]+\)\)|[^()[:space:]]+)\)`)
+var urlRegex = regexp.MustCompile(`[[:alpha:]][[:alnum:]+.-]*://[^[:space:]<>()\[\]{}"']+`)
+
+// parseLabeledLinks extracts [label](target) links, org [[target][label]] file and url links, and bare urls from a
+// line. It returns the links along with masked with their spans blanked out, so the page and block references inside
+// them are not extracted a second time.
+func (d *Document) parseLabeledLinks(line int, content string, masked string) ([]Link, string) {
+	var links []Link
+	blanked := []byte(masked)
+	add := func(t linkType, target, label string, start, end int) {
+		link := d.newLink(target, t, line, content, start, end)
+		link.Label = label
+		links = append(links, link)
+		for i := start; i < end; i++ {
+			blanked[i] = ' '
+		}
+	}
+	if d.Format == Org {
+		for _, match := range orgLinkRegex.FindAllStringSubmatchIndex(masked, -1) {
+			target := content[match[4]:match[5]]
+			if isOrgPageTarget(target) {
+				continue
+			}
+			label := ""
+			if match[6] != -1 {
+				label = content[match[6]:match[7]]
+			}
+			t, target := classifyDestination(target)
+			add(t, target, label, match[2], match[3])
+		}
+	} else {
+		for _, match := range labeledLinkRegex.FindAllStringSubmatchIndex(masked, -1) {
+			t, target := classifyDestination(content[match[6]:match[7]])
+			add(t, target, content[match[4]:match[5]], match[0], match[1])
+		}
+	}
+	for _, match := range urlRegex.FindAllStringIndex(string(blanked), -1) {
+		end := match[0] + len(strings.TrimRight(content[match[0]:match[1]], ".,;:!?"))
+		add(External, content[match[0]:end], "", match[0], end)
+	}
+	return links, string(blanked)
+}
+
+// classifyDestination works out what the target of a labeled link points at: a [[page]], a ((block)), an external url
+// or an asset file, returning the link type and the bare target.
+func classifyDestination(target string) (linkType, string) {
+	switch {
+	case strings.HasPrefix(target, "[[") && strings.HasSuffix(target, "]]"):
+		return Wiki, target[2 : len(target)-2]
+	case strings.HasPrefix(target, "((") && strings.HasSuffix(target, "))"):
+		return BlockEmbed, target[2 : len(target)-2]
+	case strings.HasPrefix(target, "file:"):
+		return Asset, strings.TrimPrefix(strings.TrimPrefix(target, "file://"), "file:")
+	case strings.Contains(target, "://") || strings.HasPrefix(target, "mailto:"):
+		return External, target
+	}
+	return Asset, target
+}
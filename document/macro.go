@@ -0,0 +1,129 @@
+package document
+
+import (
+	"fmt"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// MacroSpec describes the arguments a built-in macro accepts, MaxArgs is -1 when there is no upper bound.
+type MacroSpec struct {
+	MinArgs     int
+	MaxArgs     int
+	Description string
+}
+
+// BuiltinMacros are the {{macros}} logseq renders itself. {{query}} and {{embed}} are parsed as Query and reference
+// links instead of Macro links.
+var BuiltinMacros = map[string]MacroSpec{
+	"query":                {1, -1, "Runs a simple query"},
+	"embed":                {1, 1, "Embeds a page or block"},
+	"renderer":             {1, -1, "Renders the block with a plugin provided renderer"},
+	"video":                {1, 1, "Embeds a video from a url"},
+	"youtube":              {1, 1, "Embeds a youtube video"},
+	"youtube-timestamp":    {1, 1, "Links to a time in the youtube video of the block"},
+	"vimeo":                {1, 1, "Embeds a vimeo video"},
+	"bilibili":             {1, 1, "Embeds a bilibili video"},
+	"tweet":                {1, 1, "Embeds a tweet"},
+	"twitter":              {1, 1, "Embeds a tweet"},
+	"pdf":                  {1, 1, "Embeds a pdf"},
+	"cloze":                {1, -1, "Hides the text until it is revealed in card review"},
+	"cards":                {0, 1, "Shows the flashcards matching a query"},
+	"namespace":            {1, 1, "Lists the pages in a namespace"},
+	"function":             {1, 1, "Calculates a value from the results of the query in the parent block"},
+	"zotero-imported-file": {2, 2, "Links to a file imported from zotero"},
+	"zotero-linked-file":   {1, 1, "Links to a file linked from zotero"},
+}
+
+var macroRegex = regexp.MustCompile(`{{([^[:space:]{}]+)(?:[ \t]+(.*?))?}}`)
+var macroArgRegex = regexp.MustCompile(`\$(\d+)`)
+
+// parseMacros extracts a Macro link for every {{macro}} of a line apart from query and embed.
+func (d *Document) parseMacros(line int, content string, masked string) []Link {
+	var links []Link
+	for _, match := range macroRegex.FindAllStringSubmatchIndex(masked, -1) {
+		name := content[match[2]:match[3]]
+		if name == "query" || name == "embed" {
+			continue
+		}
+		link := d.newLink(name, Macro, line, content, match[0], match[1])
+		if match[4] != -1 {
+			args := content[match[4]:match[5]]
+			for _, span := range splitTopLevel(args) {
+				link.Args = append(link.Args, strings.TrimSpace(args[span[0]:span[1]]))
+			}
+		}
+		links = append(links, link)
+	}
+	return links
+}
+
+// ExpandMacro substitutes the $1, $2... placeholders of a user macro from config.edn with args.
+func ExpandMacro(template string, args []string) string {
+	return macroArgRegex.ReplaceAllStringFunc(template, func(placeholder string) string {
+		i, err := strconv.Atoi(placeholder[1:])
+		if err != nil || i < 1 || i > len(args) {
+			return placeholder
+		}
+		return args[i-1]
+	})
+}
+
+// MacroArity returns the number of arguments a user macro uses, the highest $n placeholder in its template.
+func MacroArity(template string) int {
+	arity := 0
+	for _, match := range macroArgRegex.FindAllStringSubmatch(template, -1) {
+		if i, err := strconv.Atoi(match[1]); err == nil && i > arity {
+			arity = i
+		}
+	}
+	return arity
+}
+
+// MacroDiagnostics reports the macros of the document that are neither built in nor defined in macros, the user
+// macros of config.edn, and those called with the wrong number of arguments.
+func (d Document) MacroDiagnostics(macros map[string]string) []protocol.Diagnostic {
+	var diagnostics []protocol.Diagnostic
+	severity := protocol.DiagnosticSeverityWarning
+	source := "logseq"
+	add := func(l Link, message string) {
+		diagnostics = append(diagnostics, protocol.Diagnostic{
+			Range:    l.Range,
+			Severity: &severity,
+			Source:   &source,
+			Message:  message,
+		})
+	}
+	for _, l := range d.Links {
+		if l.Type != Macro {
+			continue
+		}
+		if template, ok := macros[l.Target]; ok {
+			if arity := MacroArity(template); len(l.Args) != arity {
+				add(l, fmt.Sprintf("macro %s expects %d arguments, got %d", l.Target, arity, len(l.Args)))
+			}
+			continue
+		}
+		spec, ok := BuiltinMacros[l.Target]
+		if !ok {
+			add(l, fmt.Sprintf("unknown macro %s", l.Target))
+			continue
+		}
+		if len(l.Args) < spec.MinArgs || (spec.MaxArgs != -1 && len(l.Args) > spec.MaxArgs) {
+			add(l, fmt.Sprintf("macro %s expects %s arguments, got %d", l.Target, spec.arity(), len(l.Args)))
+		}
+	}
+	return diagnostics
+}
+
+func (s MacroSpec) arity() string {
+	switch {
+	case s.MaxArgs == -1:
+		return fmt.Sprintf("at least %d", s.MinArgs)
+	case s.MinArgs == s.MaxArgs:
+		return strconv.Itoa(s.MinArgs)
+	}
+	return fmt.Sprintf("%d to %d", s.MinArgs, s.MaxArgs)
+}
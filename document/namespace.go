@@ -0,0 +1,39 @@
+package document
+
+import (
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+)
+
+// NamespaceAt returns the target of a page link up to the end of the namespace segment at pos, such as
+// "project/backend" for a position on "backend" in [[project/backend/api]]. The whole target is returned when pos is
+// not on the target text.
+func (d Document) NamespaceAt(l Link, pos protocol.Position) string {
+	start, end := d.Offset(l.Range.Start), d.Offset(l.Range.End)
+	if start > end || end > len(d.Contents) {
+		return l.Target
+	}
+	i := strings.Index(d.Contents[start:end], l.Target)
+	if i == -1 {
+		return l.Target
+	}
+	cursor := d.Offset(pos) - start - i
+	if cursor < 0 || cursor > len(l.Target) {
+		return l.Target
+	}
+	segmentEnd := strings.IndexByte(l.Target[cursor:], '/')
+	if segmentEnd == -1 || cursor+segmentEnd == 0 {
+		return l.Target
+	}
+	return l.Target[:cursor+segmentEnd]
+}
+
+// NamespaceParent returns the namespace a page belongs to, "project/backend" for "project/backend/api", or an empty
+// string for a page outside of any namespace.
+func NamespaceParent(name string) string {
+	i := strings.LastIndexByte(name, '/')
+	if i == -1 {
+		return ""
+	}
+	return name[:i]
+}
@@ -0,0 +1,135 @@
+package document
+
+import (
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+	"unicode/utf8"
+)
+
+// PositionEncoding is the unit Position.Character is counted in, as negotiated with the client.
+type PositionEncoding string
+
+const (
+	UTF8  PositionEncoding = "utf-8"
+	UTF16 PositionEncoding = "utf-16"
+	UTF32 PositionEncoding = "utf-32"
+)
+
+// ParsePositionEncoding returns the first of the client's offered encodings this package supports, falling back to
+// UTF16 which every client must support.
+func ParsePositionEncoding(offered []string) PositionEncoding {
+	for _, o := range offered {
+		switch e := PositionEncoding(o); e {
+		case UTF8, UTF16, UTF32:
+			return e
+		}
+	}
+	return UTF16
+}
+
+// Column converts a byte index within line into a character offset in the encoding.
+func (e PositionEncoding) Column(line string, index int) protocol.UInteger {
+	if index > len(line) {
+		index = len(line)
+	}
+	switch e {
+	case UTF8:
+		return protocol.UInteger(index)
+	case UTF32:
+		return protocol.UInteger(utf8.RuneCountInString(line[:index]))
+	}
+	column := 0
+	for _, r := range line[:index] {
+		column++
+		if r >= 0x10000 {
+			column++
+		}
+	}
+	return protocol.UInteger(column)
+}
+
+// Index converts a character offset in the encoding into a byte index within line. Offsets past the end of the line
+// default back to the line length.
+func (e PositionEncoding) Index(line string, character protocol.UInteger) int {
+	if e == UTF8 {
+		if int(character) > len(line) {
+			return len(line)
+		}
+		return int(character)
+	}
+	column := protocol.UInteger(0)
+	for i, r := range line {
+		if column >= character {
+			return i
+		}
+		column++
+		if e == UTF16 && r >= 0x10000 {
+			column++
+		}
+	}
+	return len(line)
+}
+
+func lineOffsets(contents string) []int {
+	offsets := []int{0}
+	for i := strings.IndexByte(contents, '\n'); i != -1; {
+		offsets = append(offsets, offsets[len(offsets)-1]+i+1)
+		i = strings.IndexByte(contents[offsets[len(offsets)-1]:], '\n')
+	}
+	return offsets
+}
+
+// line returns the contents of the given line without its line break.
+func (d Document) line(line int) string {
+	if line < 0 || line >= len(d.lines) {
+		return ""
+	}
+	end := len(d.Contents)
+	if line+1 < len(d.lines) {
+		end = d.lines[line+1] - 1
+	}
+	return d.Contents[d.lines[line]:end]
+}
+
+// Offset converts pos into a byte offset into Contents.
+func (d Document) Offset(pos protocol.Position) int {
+	if int(pos.Line) >= len(d.lines) {
+		return len(d.Contents)
+	}
+	return d.lines[pos.Line] + d.Encoding.Index(d.line(int(pos.Line)), pos.Character)
+}
+
+// Position converts a byte offset into Contents into a Position.
+func (d Document) Position(offset int) protocol.Position {
+	if offset > len(d.Contents) {
+		offset = len(d.Contents)
+	}
+	line := 0
+	for line+1 < len(d.lines) && d.lines[line+1] <= offset {
+		line++
+	}
+	return protocol.Position{
+		Line:      protocol.UInteger(line),
+		Character: d.Encoding.Column(d.line(line), offset-d.lines[line]),
+	}
+}
+
+// RangeContains reports whether pos lies within rng, including its end.
+func (d Document) RangeContains(rng protocol.Range, pos protocol.Position) bool {
+	i := d.Offset(pos)
+	return i >= d.Offset(rng.Start) && i <= d.Offset(rng.End)
+}
+
+// newRange builds a range on a single line from byte indexes into content.
+func (d Document) newRange(line int, content string, start, end int) protocol.Range {
+	return protocol.Range{
+		Start: protocol.Position{
+			Line:      protocol.UInteger(line),
+			Character: d.Encoding.Column(content, start),
+		},
+		End: protocol.Position{
+			Line:      protocol.UInteger(line),
+			Character: d.Encoding.Column(content, end),
+		},
+	}
+}
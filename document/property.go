@@ -0,0 +1,115 @@
+package document
+
+import "strings"
+
+// DefaultSeparatedByCommas are the properties whose plain comma separated values logseq always treats as pages.
+var DefaultSeparatedByCommas = []string{"alias", "tags"}
+
+// WithSeparatedByCommas adds to the properties, from :property/separated-by-commas in config.edn, whose plain comma
+// separated values are page references.
+func WithSeparatedByCommas(keys ...string) Option {
+	return func(d *Document) {
+		for _, k := range keys {
+			d.separatedByCommas[strings.ToLower(k)] = true
+		}
+	}
+}
+
+// SeparatedByCommas reports whether the plain comma separated values of the property key are page references.
+func (d Document) SeparatedByCommas(key string) bool {
+	return d.separatedByCommas[strings.ToLower(key)]
+}
+
+// PropertyValueSpans returns the start and end byte indexes of the comma separated items of a property value, commas
+// inside brackets and quotes do not separate items.
+func PropertyValueSpans(value string) [][2]int {
+	return splitTopLevel(value)
+}
+
+// propertyItem is a single value of a property such as [[b c]] in "a, [[b c]], #d".
+type propertyItem struct {
+	// start and end are byte indexes into the property value
+	start, end int
+	// target is the page the item refers to, empty for quoted strings
+	target string
+	// ref is true for items written as an explicit [[page]] or #tag reference
+	ref bool
+}
+
+// splitPropertyValue splits a property value on commas that are outside of brackets and quotes.
+func splitPropertyValue(value string) []propertyItem {
+	var items []propertyItem
+	for _, span := range splitTopLevel(value) {
+		if item, ok := newPropertyItem(value, span[0], span[1]); ok {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// splitTopLevel returns the start and end byte indexes of the comma separated parts of value, ignoring commas inside
+// [[brackets]], ((block refs)) and quotes.
+func splitTopLevel(value string) [][2]int {
+	var spans [][2]int
+	depth, quoted, start := 0, false, 0
+	for i := 0; i <= len(value); i++ {
+		if i < len(value) {
+			switch {
+			case strings.HasPrefix(value[i:], "[[") || strings.HasPrefix(value[i:], "(("):
+				depth++
+				i++
+				continue
+			case (strings.HasPrefix(value[i:], "]]") || strings.HasPrefix(value[i:], "))")) && depth > 0:
+				depth--
+				i++
+				continue
+			case value[i] == '"':
+				quoted = !quoted
+				continue
+			case value[i] != ',' || depth > 0 || quoted:
+				continue
+			}
+		}
+		spans = append(spans, [2]int{start, i})
+		start = i + 1
+	}
+	return spans
+}
+
+func newPropertyItem(value string, start, end int) (propertyItem, bool) {
+	raw := value[start:end]
+	trimmed := strings.TrimSpace(raw)
+	if trimmed == "" {
+		return propertyItem{}, false
+	}
+	start += strings.Index(raw, trimmed)
+	item := propertyItem{start: start, end: start + len(trimmed)}
+	inner := strings.TrimPrefix(trimmed, "#")
+	switch {
+	case len(trimmed) >= 2 && strings.HasPrefix(trimmed, `"`) && strings.HasSuffix(trimmed, `"`):
+	case strings.HasPrefix(inner, "[[") && strings.HasSuffix(inner, "]]") && !strings.Contains(inner[2:len(inner)-2], "]]"):
+		item.target, item.ref = inner[2:len(inner)-2], true
+	case strings.HasPrefix(trimmed, "#") && !strings.ContainsAny(inner, " \t"):
+		item.target, item.ref = inner, true
+	default:
+		item.target = trimmed
+	}
+	return item, true
+}
+
+// parsePropertyValues fills in the pages a property value refers to and returns a PropValue link for every plain value
+// of a comma separated property. Bracketed and tag values are left to the wiki and tag links of the line.
+func (d *Document) parsePropertyValues(p *Property, line int, content string, valueStart int) []Link {
+	var links []Link
+	separated := d.SeparatedByCommas(p.Key)
+	for _, item := range splitPropertyValue(p.Value) {
+		if item.target == "" || (!item.ref && !separated) {
+			continue
+		}
+		p.Values = append(p.Values, item.target)
+		if !item.ref {
+			links = append(links, d.newLink(item.target, PropValue, line, content, valueStart+item.start, valueStart+item.end))
+		}
+	}
+	return links
+}
@@ -0,0 +1,49 @@
+package document
+
+import (
+	"github.com/WhiskeyJack96/logseqlsp/edn"
+	"strings"
+)
+
+// DatalogQuery is the map of an advanced query block such as
+//
+//	#+BEGIN_QUERY
+//	{:title "Todos" :query [:find (pull ?b [*]) :where [?b :block/marker "TODO"]] :inputs [:today]}
+//	#+END_QUERY
+type DatalogQuery struct {
+	Title string
+	// Query is the source text of the :query form
+	Query string
+	// Inputs holds the source text of every :inputs form, such as ":today" or "\"TODO\""
+	Inputs []string
+}
+
+// newAdvancedQueryLink builds a Query link covering a #+BEGIN_QUERY region. The target is the text between the
+// BEGIN and END lines, and Datalog is left nil when that text is not a readable query map.
+func (d *Document) newAdvancedQueryLink(r Region) Link {
+	text := d.Contents[r.Start:r.End]
+	body := ""
+	if first, last := strings.IndexByte(text, '\n'), strings.LastIndexByte(text, '\n'); first != -1 && last > first {
+		body = strings.TrimSpace(text[first+1 : last])
+	}
+	link := Link{Target: body, Type: Query, Range: r.Range}
+	if body == "" {
+		return link
+	}
+	n, err := edn.ParseOne(body)
+	if err != nil || n.Kind != edn.Map {
+		return link
+	}
+	link.Datalog = &DatalogQuery{Query: n.Get(":query").Text(body)}
+	if title := n.Get(":title"); title != nil && title.Kind == edn.String {
+		link.Datalog.Title = title.Value
+	} else {
+		link.Datalog.Title = title.Text(body)
+	}
+	if inputs := n.Get(":inputs"); inputs != nil {
+		for _, input := range inputs.Children {
+			link.Datalog.Inputs = append(link.Datalog.Inputs, input.Text(body))
+		}
+	}
+	return link
+}
@@ -0,0 +1,205 @@
+package document
+
+import (
+	"errors"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+)
+
+// Region is a span of verbatim text, such as code or math, that links are never extracted from.
+type Region struct {
+	Type  regionType
+	Range protocol.Range
+	// Start and End are the byte offsets of Range in the document contents
+	Start int
+	End   int
+
+	// closer is the marker that ends a multi-line region
+	closer string
+}
+
+type regionType string
+
+var (
+	CodeFence  regionType = "CODEFENCE"
+	InlineCode regionType = "INLINECODE"
+	Math       regionType = "MATH"
+	Source     regionType = "SOURCE"
+	// AdvancedQuery is a #+BEGIN_QUERY block holding a datalog query
+	AdvancedQuery regionType = "QUERY"
+)
+
+var ErrRegionNotFound = errors.New("region not found")
+
+// verbatimBlocks maps the org style blocks whose contents are not markup to the line that closes them.
+var verbatimBlocks = map[string]struct {
+	closer string
+	t      regionType
+}{
+	"#+BEGIN_SRC":     {"#+END_SRC", Source},
+	"#+BEGIN_EXAMPLE": {"#+END_EXAMPLE", Source},
+	"#+BEGIN_EXPORT":  {"#+END_EXPORT", Source},
+	"#+BEGIN_QUERY":   {"#+END_QUERY", AdvancedQuery},
+}
+
+func (d Document) FindRegionForPosition(pos protocol.Position) (Region, error) {
+	for _, r := range d.Regions {
+		if d.RangeContains(r.Range, pos) {
+			return r, nil
+		}
+	}
+	return Region{}, ErrRegionNotFound
+}
+
+// InCode reports whether pos is inside a code block or inline code, where text is not markup. A position right after
+// the closing backticks of inline code is outside of it.
+func (d Document) InCode(pos protocol.Position) bool {
+	offset := d.Offset(pos)
+	for _, r := range d.Regions {
+		switch r.Type {
+		case InlineCode:
+			if r.Start < offset && offset < r.End {
+				return true
+			}
+		case CodeFence, Source:
+			if r.Start < offset && offset <= r.End {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// openRegion checks whether the text of a line starting at byte index start opens a multi-line region.
+func (d *Document) openRegion(line int, content string, start, offset int) *Region {
+	text := content[start:]
+	trimmed := strings.TrimSpace(text)
+	var r *Region
+	switch {
+	case strings.HasPrefix(trimmed, "```") || strings.HasPrefix(trimmed, "~~~"):
+		marker := trimmed[:len(trimmed)-len(strings.TrimLeft(trimmed, trimmed[:1]))]
+		if strings.Contains(trimmed[len(marker):], marker) {
+			return nil
+		}
+		r = &Region{Type: CodeFence, closer: marker}
+	case strings.HasPrefix(trimmed, "$$"):
+		if strings.Contains(trimmed[2:], "$$") {
+			return nil
+		}
+		r = &Region{Type: Math, closer: "$$"}
+	default:
+		upper := strings.ToUpper(trimmed)
+		for begin, block := range verbatimBlocks {
+			if strings.HasPrefix(upper, begin) {
+				r = &Region{Type: block.t, closer: block.closer}
+				break
+			}
+		}
+		if r == nil {
+			return nil
+		}
+	}
+	r.Start = offset + start
+	r.Range.Start = protocol.Position{Line: protocol.UInteger(line), Character: d.Encoding.Column(content, start)}
+	return r
+}
+
+// closes reports whether a line inside a multi-line region ends it.
+func (r *Region) closes(content string) bool {
+	trimmed := strings.TrimSpace(content)
+	trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+	switch r.Type {
+	case Math:
+		return strings.Contains(trimmed, r.closer)
+	case Source, AdvancedQuery:
+		return strings.HasPrefix(strings.ToUpper(trimmed), r.closer)
+	}
+	return strings.HasPrefix(trimmed, r.closer) && strings.Trim(trimmed, r.closer[:1]) == ""
+}
+
+func (d *Document) closeRegion(r *Region, line int, content string, offset int) {
+	r.End = offset + len(content)
+	r.Range.End = protocol.Position{Line: protocol.UInteger(line), Character: d.Encoding.Column(content, len(content))}
+	d.Regions = append(d.Regions, *r)
+}
+
+// inlineRegions finds the inline code and math spans of a line, returning the line with every byte inside them
+// blanked out so that link extraction can run on it without needing to know about the spans.
+func (d *Document) inlineRegions(line int, content string, offset int) string {
+	var masked []byte
+	mask := func(t regionType, start, end int) {
+		if masked == nil {
+			masked = []byte(content)
+		}
+		for i := start; i < end; i++ {
+			masked[i] = ' '
+		}
+		d.Regions = append(d.Regions, Region{
+			Type:  t,
+			Range: d.newRange(line, content, start, end),
+			Start: offset + start,
+			End:   offset + end,
+		})
+	}
+	for i := 0; i < len(content); {
+		switch {
+		case content[i] == '`':
+			n := len(content[i:]) - len(strings.TrimLeft(content[i:], "`"))
+			marker := content[i : i+n]
+			end := closingRun(content, i+n, marker)
+			if end == -1 {
+				i += n
+				continue
+			}
+			mask(InlineCode, i, end+n)
+			i = end + n
+		case strings.HasPrefix(content[i:], "$$"):
+			end := strings.Index(content[i+2:], "$$")
+			if end == -1 {
+				i += 2
+				continue
+			}
+			mask(Math, i, i+2+end+2)
+			i = i + 2 + end + 2
+		case strings.HasPrefix(content[i:], `\(`):
+			end := strings.Index(content[i+2:], `\)`)
+			if end == -1 {
+				i += 2
+				continue
+			}
+			mask(Math, i, i+2+end+2)
+			i = i + 2 + end + 2
+		default:
+			i++
+		}
+	}
+	if d.Format == Org {
+		for _, span := range orgVerbatim(content) {
+			if masked == nil || strings.TrimSpace(string(masked[span[0]:span[1]])) != "" {
+				mask(InlineCode, span[0], span[1])
+			}
+		}
+	}
+	if masked == nil {
+		return content
+	}
+	return string(masked)
+}
+
+// closingRun finds the next run of backticks exactly as long as marker, starting from byte index from.
+func closingRun(content string, from int, marker string) int {
+	for i := from; i < len(content); {
+		j := strings.Index(content[i:], marker)
+		if j == -1 {
+			return -1
+		}
+		j += i
+		end := j + len(marker)
+		if end < len(content) && content[end] == '`' {
+			i = end + len(content[end:]) - len(strings.TrimLeft(content[end:], "`"))
+			continue
+		}
+		return j
+	}
+	return -1
+}
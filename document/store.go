@@ -0,0 +1,93 @@
+package document
+
+import (
+	"fmt"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"strings"
+	"sync"
+)
+
+// Store holds the live buffers of the documents the client has open, keyed by URI.
+type Store struct {
+	mu   sync.RWMutex
+	docs map[string]Document
+}
+
+func NewStore() *Store {
+	return &Store{docs: map[string]Document{}}
+}
+
+// Open parses text and stores it as the contents of uri until it is closed.
+func (s *Store) Open(uri string, text string, options ...Option) (Document, error) {
+	d, err := New(strings.NewReader(text), options...)
+	if err != nil {
+		return Document{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.docs[uri] = d
+	return d, nil
+}
+
+// Change applies the content change events of a didChange notification in order and re-parses the result.
+func (s *Store) Change(uri string, changes []any) (Document, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.docs[uri]
+	if !ok {
+		return Document{}, fmt.Errorf("document not open: %s", uri)
+	}
+	for _, change := range changes {
+		switch c := change.(type) {
+		case protocol.TextDocumentContentChangeEvent:
+			d = d.Edit(c.Range, c.Text)
+		case protocol.TextDocumentContentChangeEventWhole:
+			d = d.Edit(nil, c.Text)
+		default:
+			return Document{}, fmt.Errorf("unsupported content change: %T", change)
+		}
+	}
+	s.docs[uri] = d
+	return d, nil
+}
+
+// Close drops the buffer for uri so that it is read from disk again.
+func (s *Store) Close(uri string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.docs, uri)
+}
+
+// Get returns the open document for uri.
+func (s *Store) Get(uri string) (Document, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	d, ok := s.docs[uri]
+	return d, ok
+}
+
+// Documents returns every open document keyed by URI.
+func (s *Store) Documents() map[string]Document {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	docs := make(map[string]Document, len(s.docs))
+	for uri, d := range s.docs {
+		docs[uri] = d
+	}
+	return docs
+}
+
+// Edit returns a copy of the document with the text in rng replaced, a nil range replaces the whole document.
+func (d Document) Edit(rng *protocol.Range, text string) Document {
+	contents := text
+	if rng != nil {
+		start, end := d.Offset(rng.Start), d.Offset(rng.End)
+		if end < start {
+			start, end = end, start
+		}
+		contents = d.Contents[:start] + text + d.Contents[end:]
+	}
+	edited := d
+	edited.load(contents)
+	return edited
+}
@@ -0,0 +1,109 @@
+package document
+
+import (
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"testing"
+)
+
+func TestStoreChange(t *testing.T) {
+	rng := func(startLine, startChar, endLine, endChar protocol.UInteger) *protocol.Range {
+		return &protocol.Range{
+			Start: protocol.Position{Line: startLine, Character: startChar},
+			End:   protocol.Position{Line: endLine, Character: endChar},
+		}
+	}
+	tests := []struct {
+		name     string
+		encoding PositionEncoding
+		text     string
+		changes  []any
+		want     string
+	}{
+		{
+			name: "ranged edits apply in order",
+			text: "- first\n- second\n",
+			changes: []any{
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 2, 0, 7), Text: "[[one]]"},
+				protocol.TextDocumentContentChangeEvent{Range: rng(1, 8, 1, 8), Text: " #two"},
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 9, 1, 0), Text: " "},
+			},
+			want: "- [[one]] - second #two\n",
+		},
+		{
+			name: "whole document replacement",
+			text: "- old\n",
+			changes: []any{
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 2, 0, 5), Text: "edited"},
+				protocol.TextDocumentContentChangeEventWhole{Text: "- [[new]]\n"},
+			},
+			want: "- [[new]]\n",
+		},
+		{
+			name:     "utf-16 ranges count surrogate pairs as two",
+			encoding: UTF16,
+			text:     "- 😀 café x\n",
+			changes: []any{
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 10, 0, 11), Text: "[[y]]"},
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 5, 0, 9), Text: "tea"},
+			},
+			want: "- 😀 tea [[y]]\n",
+		},
+		{
+			name:     "utf-8 ranges count bytes",
+			encoding: UTF8,
+			text:     "- 😀 café x\n",
+			changes: []any{
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 13, 0, 14), Text: "[[y]]"},
+				protocol.TextDocumentContentChangeEvent{Range: rng(0, 7, 0, 12), Text: "tea"},
+			},
+			want: "- 😀 tea [[y]]\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewStore()
+			encoding := test.encoding
+			if encoding == "" {
+				encoding = UTF16
+			}
+			if _, err := s.Open("file:///page.md", test.text, WithEncoding(encoding)); err != nil {
+				t.Fatal(err)
+			}
+			d, err := s.Change("file:///page.md", test.changes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Contents != test.want {
+				t.Errorf("contents = %q, want %q", d.Contents, test.want)
+			}
+			stored, _ := s.Get("file:///page.md")
+			if stored.Contents != test.want {
+				t.Errorf("stored contents = %q, want %q", stored.Contents, test.want)
+			}
+			// the edited document is parsed again
+			if len(d.Links) == 0 || d.Links[0].Target != d.Contents[d.Offset(d.Links[0].Range.Start)+2:d.Offset(d.Links[0].Range.End)-2] {
+				t.Errorf("links not parsed from the edited contents: %+v", d.Links)
+			}
+		})
+	}
+}
+
+func TestStoreOpenClose(t *testing.T) {
+	s := NewStore()
+	if _, err := s.Change("file:///page.md", []any{protocol.TextDocumentContentChangeEventWhole{Text: "x"}}); err == nil {
+		t.Error("changing a document that is not open succeeded")
+	}
+	if _, err := s.Open("file:///page.md", "- [[a]]\n"); err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := s.Get("file:///page.md"); !ok || d.Contents != "- [[a]]\n" {
+		t.Errorf("open document = %q, %v", d.Contents, ok)
+	}
+	if _, err := s.Change("file:///page.md", []any{"not a change event"}); err == nil {
+		t.Error("unsupported change event accepted")
+	}
+	s.Close("file:///page.md")
+	if _, ok := s.Get("file:///page.md"); ok {
+		t.Error("closed document still stored")
+	}
+}
@@ -0,0 +1,74 @@
+package document
+
+import (
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Markers lists the task markers logseq recognises at the start of a block.
+var Markers = []string{"TODO", "DOING", "DONE", "LATER", "NOW", "WAITING", "WAIT", "CANCELED", "CANCELLED", "IN-PROGRESS"}
+
+// Timestamp is an org style SCHEDULED or DEADLINE timestamp such as <2023-01-20 Fri 10:00 .+1d>.
+type Timestamp struct {
+	Date time.Time
+	// HasTime is true when the timestamp includes a time of day
+	HasTime bool
+	// Repeater is the raw repeater cookie such as "+1w", ".+1d" or "++2m", empty when the task does not repeat
+	Repeater string
+	Range    protocol.Range
+}
+
+// markerRegex matches one of Markers at the start of the text of a block.
+var markerRegex = regexp.MustCompile(`^(` + strings.Join(Markers, "|") + `)(?:[ \t]+|$)`)
+var priorityRegex = regexp.MustCompile(`\[#([A-Ca-c])]`)
+var timestampRegex = regexp.MustCompile(`(SCHEDULED|DEADLINE):[ \t]*<(\d{4}-\d{2}-\d{2})(?:[ \t]+[[:alpha:]]+)?(?:[ \t]+(\d{1,2}:\d{2}))?(?:[ \t]+((?:\.\+|\+\+|\+)\d+[hdwmy]))?>`)
+
+// parseTask reads the marker and priority from the first line of a block, whose text starts at byte index start.
+func (d *Document) parseTask(b *Block, line int, content string, start int) {
+	text := content[start:]
+	if match := markerRegex.FindStringSubmatchIndex(text); match != nil {
+		b.Marker = text[match[2]:match[3]]
+		b.MarkerRange = d.newRange(line, content, start+match[2], start+match[3])
+	}
+	if match := priorityRegex.FindStringSubmatchIndex(text); match != nil {
+		b.Priority = strings.ToUpper(text[match[2]:match[3]])
+		b.PriorityRange = d.newRange(line, content, start+match[0], start+match[1])
+	}
+}
+
+// parseTimestamps reads SCHEDULED and DEADLINE timestamps from a line of a block.
+func (d *Document) parseTimestamps(b *Block, line int, content string, masked string) {
+	for _, match := range timestampRegex.FindAllStringSubmatchIndex(masked, -1) {
+		layout, value := "2006-01-02", content[match[4]:match[5]]
+		ts := Timestamp{Range: d.newRange(line, content, match[0], match[1])}
+		if match[6] != -1 {
+			layout, value = "2006-01-02 15:04", value+" "+content[match[6]:match[7]]
+			ts.HasTime = true
+		}
+		date, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		ts.Date = date
+		if match[8] != -1 {
+			ts.Repeater = content[match[8]:match[9]]
+		}
+		if content[match[2]:match[3]] == "SCHEDULED" {
+			b.Scheduled = &ts
+		} else {
+			b.Deadline = &ts
+		}
+	}
+}
+
+// MarkerEdit returns the edit that makes the block a task with marker, replacing the marker it already has.
+func (d Document) MarkerEdit(b *Block, marker string) protocol.TextEdit {
+	if b.Marker != "" {
+		return protocol.TextEdit{Range: b.MarkerRange, NewText: marker}
+	}
+	line := int(b.Range.Start.Line)
+	start := protocol.Position{Line: protocol.UInteger(line), Character: d.Encoding.Column(d.line(line), b.contentColumn)}
+	return protocol.TextEdit{Range: protocol.Range{Start: start, End: start}, NewText: marker + " "}
+}
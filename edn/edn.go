@@ -0,0 +1,303 @@
+// Package edn reads the subset of EDN, and the clojure reader syntax around it, that logseq writes in config.edn and
+// advanced queries. Values are kept as a tree of nodes that remember where they came from so callers can pull out the
+// source text of a form as well as its value.
+package edn
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type Kind string
+
+var (
+	Nil     Kind = "NIL"
+	Bool    Kind = "BOOL"
+	Number  Kind = "NUMBER"
+	String  Kind = "STRING"
+	Char    Kind = "CHAR"
+	Keyword Kind = "KEYWORD"
+	Symbol  Kind = "SYMBOL"
+	List    Kind = "LIST"
+	Vector  Kind = "VECTOR"
+	Map     Kind = "MAP"
+	Set     Kind = "SET"
+	Tagged  Kind = "TAGGED"
+)
+
+// Node is a single form. Scalars carry their text in Value, with strings unescaped, while collections carry their
+// elements in Children. Map children alternate between keys and values.
+type Node struct {
+	Kind     Kind
+	Value    string
+	Children []*Node
+	// Start and End are the byte offsets of the form in the source
+	Start int
+	End   int
+}
+
+var ErrUnexpectedEOF = errors.New("unexpected end of input")
+
+// Parse reads every top level form of src.
+func Parse(src string) ([]*Node, error) {
+	p := parser{src: src}
+	var nodes []*Node
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nodes, nil
+		}
+		n, err := p.form()
+		if err != nil {
+			return nil, err
+		}
+		if n != nil {
+			nodes = append(nodes, n)
+		}
+	}
+}
+
+// ParseOne reads the first form of src.
+func ParseOne(src string) (*Node, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrUnexpectedEOF
+	}
+	return nodes[0], nil
+}
+
+// Get returns the value stored under key in a map, where key is written as it appears in the source such as
+// ":pages-directory". It returns nil when n is not a map or the key is missing.
+func (n *Node) Get(key string) *Node {
+	if n == nil || n.Kind != Map {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Children); i += 2 {
+		k := n.Children[i]
+		if k.Kind == Keyword && k.Value == key || k.Kind == String && k.Value == key {
+			return n.Children[i+1]
+		}
+	}
+	return nil
+}
+
+// Text returns the source text of the form.
+func (n *Node) Text(src string) string {
+	if n == nil {
+		return ""
+	}
+	return src[n.Start:n.End]
+}
+
+// Strings returns the values of the scalar elements of a collection, or the value of a scalar.
+func (n *Node) Strings() []string {
+	if n == nil || n.Kind == Nil {
+		return nil
+	}
+	if n.Children == nil {
+		return []string{n.Value}
+	}
+	var values []string
+	for _, c := range n.Children {
+		if c.Children == nil {
+			values = append(values, c.Value)
+		}
+	}
+	return values
+}
+
+// Truthy reports whether the node is neither nil nor false.
+func (n *Node) Truthy() bool {
+	return n != nil && n.Kind != Nil && !(n.Kind == Bool && n.Value == "false")
+}
+
+type parser struct {
+	src string
+	pos int
+}
+
+// skip moves past whitespace, commas and comments.
+func (p *parser) skip() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ';':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		case c == ',' || unicode.IsSpace(rune(c)):
+			p.pos++
+		default:
+			return
+		}
+	}
+}
+
+// form reads the next form, returning nil for a #_ discarded form.
+func (p *parser) form() (*Node, error) {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return nil, ErrUnexpectedEOF
+	}
+	start := p.pos
+	switch c := p.src[p.pos]; c {
+	case '(':
+		return p.collection(List, ')', start)
+	case '[':
+		return p.collection(Vector, ']', start)
+	case '{':
+		return p.collection(Map, '}', start)
+	case ')', ']', '}':
+		return nil, fmt.Errorf("unexpected %q at %d", c, p.pos)
+	case '"':
+		return p.string(start)
+	case '\\':
+		p.pos++
+		if p.token(); p.pos == start+1 && p.pos < len(p.src) {
+			p.pos++
+		}
+		return &Node{Kind: Char, Value: p.src[start+1 : p.pos], Start: start, End: p.pos}, nil
+	case '\'', '`', '~', '@', '^':
+		// reader macros: read the form they apply to as if they were not there
+		p.pos++
+		if c == '~' && p.pos < len(p.src) && p.src[p.pos] == '@' {
+			p.pos++
+		}
+		n, err := p.form()
+		if n != nil {
+			n.Start = start
+		}
+		return n, err
+	case '#':
+		return p.dispatch(start)
+	}
+	p.token()
+	text := p.src[start:p.pos]
+	n := &Node{Value: text, Start: start, End: p.pos}
+	switch {
+	case text == "nil":
+		n.Kind = Nil
+	case text == "true" || text == "false":
+		n.Kind = Bool
+	case strings.HasPrefix(text, ":"):
+		n.Kind = Keyword
+	case isNumber(text):
+		n.Kind = Number
+	default:
+		n.Kind = Symbol
+	}
+	return n, nil
+}
+
+func (p *parser) dispatch(start int) (*Node, error) {
+	if p.pos+1 >= len(p.src) {
+		return nil, ErrUnexpectedEOF
+	}
+	switch p.src[p.pos+1] {
+	case '{':
+		p.pos++
+		return p.collection(Set, '}', start)
+	case '(':
+		p.pos++
+		return p.collection(List, ')', start)
+	case '"':
+		p.pos++
+		return p.string(start)
+	case '_':
+		p.pos += 2
+		// a discarded form may itself be a discard, as in #_#_ a b, in which case the next form is discarded too
+		for {
+			n, err := p.form()
+			if err != nil || n != nil {
+				return nil, err
+			}
+		}
+	}
+	p.pos++
+	p.token()
+	tag := p.src[start+1 : p.pos]
+	value, err := p.form()
+	if err != nil {
+		return nil, err
+	}
+	return &Node{Kind: Tagged, Value: tag, Children: []*Node{value}, Start: start, End: p.pos}, nil
+}
+
+func (p *parser) collection(kind Kind, closer byte, start int) (*Node, error) {
+	p.pos++
+	n := &Node{Kind: kind, Start: start, Children: []*Node{}}
+	for {
+		p.skip()
+		if p.pos >= len(p.src) {
+			return nil, ErrUnexpectedEOF
+		}
+		if p.src[p.pos] == closer {
+			p.pos++
+			n.End = p.pos
+			if kind == Map && len(n.Children)%2 != 0 {
+				return nil, fmt.Errorf("map at %d has an odd number of forms", start)
+			}
+			return n, nil
+		}
+		child, err := p.form()
+		if err != nil {
+			return nil, err
+		}
+		if child != nil {
+			n.Children = append(n.Children, child)
+		}
+	}
+}
+
+func (p *parser) string(start int) (*Node, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch c {
+		case '"':
+			p.pos++
+			return &Node{Kind: String, Value: b.String(), Start: start, End: p.pos}, nil
+		case '\\':
+			if p.pos+1 >= len(p.src) {
+				return nil, ErrUnexpectedEOF
+			}
+			p.pos++
+			switch e := p.src[p.pos]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				b.WriteByte(e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return nil, ErrUnexpectedEOF
+}
+
+// token moves past a symbol, keyword, number or other atom.
+func (p *parser) token() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		if c == ',' || c == ';' || c == '"' || strings.IndexByte("()[]{}", c) != -1 || unicode.IsSpace(rune(c)) {
+			return
+		}
+		p.pos++
+	}
+}
+
+func isNumber(text string) bool {
+	text = strings.TrimLeft(text, "+-")
+	return text != "" && text[0] >= '0' && text[0] <= '9'
+}
@@ -0,0 +1,43 @@
+package edn
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseAdvancedQuery(t *testing.T) {
+	src := `{:title "All todos" ; comment
+ :query [:find (pull ?b [*])
+         :where [?b :block/marker ?m]
+                [(contains? #{"TODO" "DOING"} ?m)]]
+ :inputs [:today "x\"y"]
+ #_#_:ignored true
+ :collapsed? false}`
+	n, err := ParseOne(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := n.Get(":title").Value; got != "All todos" {
+		t.Errorf("title = %q", got)
+	}
+	if got := n.Get(":query").Text(src); !strings.HasPrefix(got, "[:find") || !strings.HasSuffix(got, "?m)]]") {
+		t.Errorf("query text = %q", got)
+	}
+	if got := strings.Join(n.Get(":inputs").Strings(), "|"); got != `:today|x"y` {
+		t.Errorf("inputs = %q", got)
+	}
+	if n.Get(":ignored") != nil {
+		t.Error("discarded form was read")
+	}
+	if n.Get(":collapsed?").Truthy() {
+		t.Error("false is truthy")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, src := range []string{`{:a}`, `[1 2`, `"abc`, `)`} {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("Parse(%q) succeeded", src)
+		}
+	}
+}
@@ -3,7 +3,9 @@ module github.com/WhiskeyJack96/logseqlsp
 go 1.19
 
 require (
+	github.com/fsnotify/fsnotify v1.6.0
 	github.com/go-resty/resty/v2 v2.7.0
+	github.com/spf13/cobra v1.6.1
 	github.com/tliron/glsp v0.1.1
 	github.com/tliron/kutil v0.1.56
 	golang.org/x/exp v0.0.0-20230108222341-4b8118a2686a
@@ -18,7 +20,6 @@ require (
 	github.com/pkg/errors v0.9.1 // indirect
 	github.com/sasha-s/go-deadlock v0.3.1 // indirect
 	github.com/sourcegraph/jsonrpc2 v0.1.0 // indirect
-	github.com/spf13/cobra v1.6.1 // indirect
 	github.com/spf13/pflag v1.0.5 // indirect
 	github.com/zchee/color/v2 v2.0.6 // indirect
 	golang.org/x/crypto v0.0.0-20220214200702-86341886e292 // indirect
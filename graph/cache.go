@@ -0,0 +1,94 @@
+package graph
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// cacheVersion is bumped whenever the cached data or the way files are parsed changes, invalidating older caches.
+const cacheVersion = 6
+
+var ErrStaleCache = errors.New("cache was written for a different graph configuration")
+
+// cache is the on disk form of an index.
+type cache struct {
+	// Key identifies the graph and the settings the cached files were indexed with
+	Key   string
+	Files map[string]*file
+}
+
+// CachePath returns the file, inside dir, that the index of the graph at root is cached in.
+func CachePath(dir string, root string) string {
+	sum := sha256.Sum256([]byte(root))
+	return filepath.Join(dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// key identifies the graph and every setting that changes what indexing its files produces.
+func (ix *Index) key() string {
+	b, err := json.Marshal(struct {
+		Version  int
+		Root     string
+		Config   any
+		Encoding string
+	}{cacheVersion, ix.root, ix.config, string(ix.encoding)})
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Load fills the index from a cache written by Save. The cached files are trusted as they are, call Build afterwards
+// to pick up the files that changed since the cache was written.
+func (ix *Index) Load(p string) error {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var c cache
+	if err := json.Unmarshal(b, &c); err != nil {
+		return fmt.Errorf("error reading index cache: %w", err)
+	}
+	if c.Key != ix.key() {
+		return ErrStaleCache
+	}
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	for path, f := range c.Files {
+		if f != nil && f.Page != nil {
+			ix.add(path, f)
+		}
+	}
+	return nil
+}
+
+// Save writes the index to the cache file p, replacing it atomically so a concurrent Load never sees a partial file.
+func (ix *Index) Save(p string) error {
+	ix.mu.RLock()
+	b, err := json.Marshal(cache{Key: ix.key(), Files: ix.files})
+	ix.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0744); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), filepath.Base(p)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
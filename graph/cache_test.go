@@ -0,0 +1,77 @@
+package graph
+
+import (
+	"errors"
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	root := t.TempDir()
+	pages := filepath.Join(root, "pages")
+	if err := os.MkdirAll(pages, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"kept.md":    "- kept\n  id:: 63c5db9e-768b-4d81-965e-240b4f69e4e0\n",
+		"changed.md": "alias:: before\n",
+		"deleted.md": "- deleted\n",
+	} {
+		if err := os.WriteFile(filepath.Join(pages, name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := New(root)
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	cache := CachePath(t.TempDir(), root)
+	if err := ix.Save(cache); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := New(root)
+	if err := loaded.Load(cache); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Block("63c5db9e-768b-4d81-965e-240b4f69e4e0"); !ok {
+		t.Error("cached block not loaded")
+	}
+	if _, ok := loaded.Page("before"); !ok {
+		t.Error("cached alias not loaded")
+	}
+
+	changed := filepath.Join(pages, "changed.md")
+	if err := os.WriteFile(changed, []byte("alias:: after\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(changed, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(pages, "deleted.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := loaded.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Page("after"); !ok {
+		t.Error("changed file not indexed again")
+	}
+	if _, ok := loaded.Page("before"); ok {
+		t.Error("stale alias kept")
+	}
+	if _, ok := loaded.Page("deleted"); ok {
+		t.Error("deleted file kept")
+	}
+	if _, ok := loaded.Page("kept"); !ok {
+		t.Error("unchanged file dropped")
+	}
+
+	if err := New(root, WithEncoding(document.UTF8)).Load(cache); !errors.Is(err, ErrStaleCache) {
+		t.Errorf("loading a cache with another encoding = %v, want ErrStaleCache", err)
+	}
+}
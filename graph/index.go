@@ -0,0 +1,637 @@
+// Package graph indexes the pages, aliases and block uuids of a logseq graph straight from its files so links can be
+// resolved without the logseq api.
+package graph
+
+import (
+	"errors"
+	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	"github.com/WhiskeyJack96/logseqlsp/files"
+	"github.com/WhiskeyJack96/logseqlsp/logseq"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Page is a page of the graph backed by a file.
+type Page struct {
+	// Name is the page name with its original case, the title:: property when the page has one
+	Name string
+	// Aliases are the other names given to the page by its alias:: property
+	Aliases []string
+	Path    string
+	URI     protocol.DocumentUri
+	Format  document.Format
+	Journal bool
+	// Date is the day of a journal page
+	Date time.Time
+}
+
+// Block is a block of the graph. Blocks with an id:: property are the targets of ((uuid)) references.
+type Block struct {
+	// UUID is empty when the block has no id:: property
+	UUID string
+	// Page is the name of the page the block is on
+	Page  string
+	URI   protocol.DocumentUri
+	Range protocol.Range
+	// Text is the content of the block without its property lines
+	Text string
+	// Template is the name of the template the block defines with its template:: property
+	Template string
+}
+
+// Ref is a link from a file of the graph to a page: a [[link]], #tag, property key or property value.
+type Ref struct {
+	// Name is the page name as the link writes it
+	Name string
+	// Type is the document link type of the link
+	Type  string
+	Range protocol.Range
+}
+
+// Property is a property set on a block of the graph.
+type Property struct {
+	// Key is lower case, as logseq compares keys
+	Key string
+	// Values are the comma separated items of the value for properties that hold lists, the whole value otherwise
+	Values []string
+}
+
+// file is what the index holds for one file of the graph. ModTime and Size tell whether the file changed since.
+type file struct {
+	Page       *Page
+	Blocks     []Block
+	Refs       []Ref
+	Properties []Property
+	ModTime    time.Time
+	Size       int64
+
+	// search holds the lower case text of each of Blocks, for SearchBlocks
+	search []string
+}
+
+// Index maps page names, aliases and block uuids to the files that define them. It is safe for concurrent use.
+type Index struct {
+	root     string
+	config   logseq.Config
+	encoding document.PositionEncoding
+	options  []document.Option
+
+	mu sync.RWMutex
+	// pages and aliases are keyed by lower case name
+	pages   map[string]*Page
+	aliases map[string]*Page
+	files   map[string]*file
+	blocks  map[string]Block
+	// usage counts the links to every page name, keyed by lower case name, and usageNames keeps the name as first
+	// written
+	usage      map[string]int
+	usageNames map[string]string
+	// properties counts how often every value is set for every lower case property key, and propertyKeys how often
+	// each key is set
+	properties   map[string]map[string]int
+	propertyKeys map[string]int
+}
+
+type Option func(ix *Index)
+
+// WithConfig sets the config.edn settings that decide where pages are stored and how their files are named. The
+// defaults of logseq.DefaultConfig are used otherwise.
+func WithConfig(c logseq.Config) Option {
+	return func(ix *Index) {
+		ix.config = c
+	}
+}
+
+// WithEncoding sets the position encoding of the block ranges in the index, UTF16 by default.
+func WithEncoding(e document.PositionEncoding) Option {
+	return func(ix *Index) {
+		ix.encoding = e
+	}
+}
+
+// WithDocumentOptions sets the options every file of the graph is parsed with.
+func WithDocumentOptions(options ...document.Option) Option {
+	return func(ix *Index) {
+		ix.options = append(ix.options, options...)
+	}
+}
+
+// New returns an empty index of the graph at root, call Build to fill it.
+func New(root string, options ...Option) *Index {
+	ix := &Index{
+		root:         root,
+		config:       logseq.DefaultConfig(),
+		encoding:     document.UTF16,
+		pages:        map[string]*Page{},
+		aliases:      map[string]*Page{},
+		files:        map[string]*file{},
+		blocks:       map[string]Block{},
+		usage:        map[string]int{},
+		usageNames:   map[string]string{},
+		properties:   map[string]map[string]int{},
+		propertyKeys: map[string]int{},
+	}
+	for _, option := range options {
+		option(ix)
+	}
+	return ix
+}
+
+// Build scans the pages and journals directories and indexes every markdown and org file in them. Files already in
+// the index, such as those loaded from a cache, are only parsed again when their modification time or size changed,
+// and files that no longer exist are dropped. Files that cannot be read are skipped and reported in the returned
+// error.
+func (ix *Index) Build() error {
+	// files the watchers add while the directories are walked are not seen by the walk, so only the files indexed
+	// before are candidates for removal
+	ix.mu.RLock()
+	indexed := make([]string, 0, len(ix.files))
+	for p := range ix.files {
+		indexed = append(indexed, p)
+	}
+	ix.mu.RUnlock()
+	seen := map[string]bool{}
+	var errs []error
+	for _, dir := range []string{ix.config.PagesDirectory, ix.config.JournalsDirectory} {
+		err := filepath.WalkDir(filepath.Join(ix.root, dir), func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if entry.IsDir() || !isGraphFile(p) {
+				return nil
+			}
+			seen[p] = true
+			if info, err := entry.Info(); err == nil && ix.unchanged(p, info) {
+				return nil
+			}
+			if err := ix.Update(p); err != nil {
+				errs = append(errs, err)
+			}
+			return nil
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, p := range indexed {
+		if seen[p] {
+			continue
+		}
+		// a file removed before the walk may have been created again and refreshed since
+		if _, err := os.Stat(p); errors.Is(err, fs.ErrNotExist) {
+			ix.Remove(p)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not index %d files: %w", len(errs), errs[0])
+	}
+	return nil
+}
+
+// unchanged reports whether the index holds the file at p as it is described by info.
+func (ix *Index) unchanged(p string, info fs.FileInfo) bool {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	f, ok := ix.files[p]
+	return ok && f.ModTime.Equal(info.ModTime()) && f.Size == info.Size()
+}
+
+// Update parses the file at p and replaces whatever the index held for it.
+func (ix *Index) Update(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	options := append([]document.Option{document.WithEncoding(ix.encoding)}, ix.options...)
+	d, err := document.New(f, append(options, document.WithFormat(document.FormatForPath(p)))...)
+	if err != nil {
+		return err
+	}
+	indexed := &file{Page: ix.newPage(p, d), ModTime: info.ModTime(), Size: info.Size()}
+	d.Walk(func(b *document.Block) bool {
+		id, _ := b.Property("id")
+		if b.Bullet || id != "" {
+			text := strings.TrimSpace(strings.Join(b.Text(), "\n"))
+			template, _ := b.Property("template")
+			indexed.Blocks = append(indexed.Blocks, Block{UUID: id, Page: indexed.Page.Name, URI: indexed.Page.URI, Range: b.Range, Text: text, Template: template})
+		}
+		for _, p := range b.Properties {
+			if p.Key != "id" {
+				indexed.Properties = append(indexed.Properties, Property{Key: strings.ToLower(p.Key), Values: propertyValues(d, p)})
+			}
+		}
+		return true
+	})
+	indexed.Refs = DocumentRefs(d)
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.add(p, indexed)
+	return nil
+}
+
+func (ix *Index) add(p string, f *file) {
+	ix.remove(p)
+	ix.files[p] = f
+	f.search = make([]string, len(f.Blocks))
+	for i, b := range f.Blocks {
+		f.search[i] = strings.ToLower(b.Text)
+	}
+	ix.pages[NormalizeName(f.Page.Name)] = f.Page
+	for _, alias := range f.Page.Aliases {
+		ix.aliases[NormalizeName(alias)] = f.Page
+	}
+	for _, b := range f.Blocks {
+		if b.UUID != "" {
+			ix.blocks[strings.ToLower(b.UUID)] = b
+		}
+	}
+	for _, r := range f.Refs {
+		if !countsAsUsage(f, r) {
+			continue
+		}
+		key := NormalizeName(r.Name)
+		if ix.usage[key] == 0 {
+			ix.usageNames[key] = r.Name
+		}
+		ix.usage[key]++
+	}
+	for _, p := range f.Properties {
+		ix.propertyKeys[p.Key]++
+		if ix.properties[p.Key] == nil {
+			ix.properties[p.Key] = map[string]int{}
+		}
+		for _, v := range p.Values {
+			ix.properties[p.Key][v]++
+		}
+	}
+}
+
+// DocumentRefs returns the links of d that refer to pages: [[links]], #tags, property keys and property values.
+func DocumentRefs(d document.Document) []Ref {
+	var refs []Ref
+	for _, l := range d.Links {
+		switch l.Type {
+		case document.Wiki, document.Tag, document.Prop, document.PropValue:
+			if l.Target != "" {
+				refs = append(refs, Ref{Name: l.Target, Type: string(l.Type), Range: l.Range})
+			}
+		}
+	}
+	return refs
+}
+
+// propertyValues splits the value of p into the items it lists. Values are lists when the key is separated by commas or
+// they refer to pages, otherwise the value is kept whole.
+func propertyValues(d document.Document, p document.Property) []string {
+	value := strings.TrimSpace(p.Value)
+	if value == "" {
+		return nil
+	}
+	if !d.SeparatedByCommas(p.Key) && len(p.Values) == 0 {
+		return []string{value}
+	}
+	var values []string
+	for _, span := range document.PropertyValueSpans(value) {
+		if item := strings.TrimSpace(value[span[0]:span[1]]); item != "" {
+			values = append(values, item)
+		}
+	}
+	return values
+}
+
+// countsAsUsage reports whether a ref of the file f counts towards the usage of a page. Property keys such as id:: are
+// on so many blocks that they would drown out the pages people actually link to, and the values of the file's own
+// alias:: property name the page itself rather than link to it.
+func countsAsUsage(f *file, r Ref) bool {
+	switch r.Type {
+	case string(document.Prop):
+		return false
+	case string(document.PropValue):
+		for _, alias := range f.Page.Aliases {
+			if NormalizeName(alias) == NormalizeName(r.Name) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Remove drops the page and blocks of the file at p from the index.
+func (ix *Index) Remove(p string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.remove(p)
+}
+
+func (ix *Index) remove(p string) {
+	f, ok := ix.files[p]
+	if !ok {
+		return
+	}
+	page := f.Page
+	delete(ix.files, p)
+	if ix.pages[NormalizeName(page.Name)] == page {
+		delete(ix.pages, NormalizeName(page.Name))
+	}
+	for _, alias := range page.Aliases {
+		if ix.aliases[NormalizeName(alias)] == page {
+			delete(ix.aliases, NormalizeName(alias))
+		}
+	}
+	for _, b := range f.Blocks {
+		if b.UUID != "" && ix.blocks[strings.ToLower(b.UUID)].URI == page.URI {
+			delete(ix.blocks, strings.ToLower(b.UUID))
+		}
+	}
+	for _, r := range f.Refs {
+		if !countsAsUsage(f, r) {
+			continue
+		}
+		key := NormalizeName(r.Name)
+		if ix.usage[key]--; ix.usage[key] <= 0 {
+			delete(ix.usage, key)
+			delete(ix.usageNames, key)
+		}
+	}
+	for _, p := range f.Properties {
+		if ix.propertyKeys[p.Key]--; ix.propertyKeys[p.Key] <= 0 {
+			delete(ix.propertyKeys, p.Key)
+		}
+		values := ix.properties[p.Key]
+		for _, v := range p.Values {
+			if values[v]--; values[v] <= 0 {
+				delete(values, v)
+			}
+		}
+		if len(values) == 0 {
+			delete(ix.properties, p.Key)
+		}
+	}
+}
+
+// Page looks up a page by name or alias, ignoring case. A page named after an alias wins over the alias.
+func (ix *Index) Page(name string) (Page, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	key := NormalizeName(name)
+	if page, ok := ix.pages[key]; ok {
+		return *page, true
+	}
+	if page, ok := ix.aliases[key]; ok {
+		return *page, true
+	}
+	return Page{}, false
+}
+
+// Children returns the names of the pages directly inside the namespace name, ignoring case. Namespaces that only
+// exist as the parent of deeper pages, such as a/b when only a/b/c has a file, are included.
+func (ix *Index) Children(name string) []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	prefix := NormalizeName(name) + "/"
+	depth := strings.Count(prefix, "/")
+	seen := map[string]bool{}
+	var children []string
+	for key, page := range ix.pages {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		child := strings.Join(strings.Split(strings.TrimSpace(page.Name), "/")[:depth+1], "/")
+		if !seen[NormalizeName(child)] {
+			seen[NormalizeName(child)] = true
+			children = append(children, child)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return NormalizeName(children[i]) < NormalizeName(children[j])
+	})
+	return children
+}
+
+// References returns the location of every link in the graph to the page name, including the links to its aliases,
+// sorted by file and position.
+func (ix *Index) References(name string) []protocol.Location {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	key := ix.pageKey(name)
+	var locations []protocol.Location
+	for _, f := range ix.files {
+		for _, r := range f.Refs {
+			if ix.pageKey(r.Name) == key {
+				locations = append(locations, protocol.Location{URI: f.Page.URI, Range: r.Range})
+			}
+		}
+	}
+	SortLocations(locations)
+	return locations
+}
+
+// pageKey is the normalized name of the page name refers to, following aliases.
+func (ix *Index) pageKey(name string) string {
+	key := NormalizeName(name)
+	if page, ok := ix.pages[key]; ok {
+		return NormalizeName(page.Name)
+	}
+	if page, ok := ix.aliases[key]; ok {
+		return NormalizeName(page.Name)
+	}
+	return key
+}
+
+// SortLocations orders locations by file and position.
+func SortLocations(locations []protocol.Location) {
+	sort.Slice(locations, func(i, j int) bool {
+		a, b := locations[i], locations[j]
+		if a.URI != b.URI {
+			return a.URI < b.URI
+		}
+		if a.Range.Start.Line != b.Range.Start.Line {
+			return a.Range.Start.Line < b.Range.Start.Line
+		}
+		return a.Range.Start.Character < b.Range.Start.Character
+	})
+}
+
+// PageForURI returns the page stored in the file at uri.
+func (ix *Index) PageForURI(uri protocol.DocumentUri) (Page, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for _, f := range ix.files {
+		if f.Page.URI == uri {
+			return *f.Page, true
+		}
+	}
+	return Page{}, false
+}
+
+// Usage returns the number of links, tags and property values in the graph that refer to the page name.
+func (ix *Index) Usage(name string) int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return ix.usage[NormalizeName(name)]
+}
+
+// ReferencedNames returns the names of every page the graph links to, whether or not the page has a file, sorted
+// by name.
+func (ix *Index) ReferencedNames() []string {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	names := make([]string, 0, len(ix.usageNames))
+	for _, name := range ix.usageNames {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return NormalizeName(names[i]) < NormalizeName(names[j])
+	})
+	return names
+}
+
+// PropertyKeys returns the property keys set in the graph and how many blocks set each of them.
+func (ix *Index) PropertyKeys() map[string]int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	keys := make(map[string]int, len(ix.propertyKeys))
+	for key, n := range ix.propertyKeys {
+		keys[key] = n
+	}
+	return keys
+}
+
+// PropertyValues returns the values the graph sets the property key to and how often each of them is set.
+func (ix *Index) PropertyValues(key string) map[string]int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	values := make(map[string]int, len(ix.properties[strings.ToLower(key)]))
+	for value, n := range ix.properties[strings.ToLower(key)] {
+		values[value] = n
+	}
+	return values
+}
+
+// NormalizeName returns the key logseq compares page names by, names differing only in case or surrounding
+// whitespace refer to the same page.
+func NormalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// Block looks up a block by the uuid of its id:: property.
+func (ix *Index) Block(uuid string) (Block, bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	b, ok := ix.blocks[strings.ToLower(uuid)]
+	return b, ok
+}
+
+// WalkBlocks calls fn for every block of the graph until it returns false. The index is locked for reading while fn
+// runs, so fn must not change it.
+func (ix *Index) WalkBlocks(fn func(b Block) bool) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for _, f := range ix.files {
+		for _, b := range f.Blocks {
+			if !fn(b) {
+				return
+			}
+		}
+	}
+}
+
+// SearchBlocks calls fn for every block of the graph whose text contains all of words, ignoring case, until it returns
+// false. Like WalkBlocks the index is locked for reading while fn runs.
+func (ix *Index) SearchBlocks(words []string, fn func(b Block) bool) {
+	lower := make([]string, len(words))
+	for i, word := range words {
+		lower[i] = strings.ToLower(word)
+	}
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for _, f := range ix.files {
+	blocks:
+		for i, b := range f.Blocks {
+			for _, word := range lower {
+				if !strings.Contains(f.search[i], word) {
+					continue blocks
+				}
+			}
+			if !fn(b) {
+				return
+			}
+		}
+	}
+}
+
+// Pages returns every indexed page sorted by name.
+func (ix *Index) Pages() []Page {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	pages := make([]Page, 0, len(ix.files))
+	for _, f := range ix.files {
+		pages = append(pages, *f.Page)
+	}
+	sort.Slice(pages, func(i, j int) bool {
+		return strings.ToLower(pages[i].Name) < strings.ToLower(pages[j].Name)
+	})
+	return pages
+}
+
+// newPage names the page stored in the file at p from its title:: property or, failing that, its file name.
+func (ix *Index) newPage(p string, d document.Document) *Page {
+	page := &Page{Path: p, URI: files.PathToFileURI(p), Format: d.Format}
+	base := strings.TrimSuffix(filepath.Base(p), filepath.Ext(p))
+	if rel, err := filepath.Rel(filepath.Join(ix.root, ix.config.JournalsDirectory), p); err == nil && !strings.HasPrefix(rel, "..") {
+		if date, err := ix.config.JournalFileNameFormat.Parse(base); err == nil {
+			page.Journal, page.Date = true, date
+			page.Name = ix.config.JournalPageTitleFormat.Format(date)
+		}
+	}
+	if page.Name == "" {
+		page.Name = ix.config.FilePageName(base)
+	}
+	if properties := pageProperties(d); properties != nil {
+		if title, ok := properties.Property("title"); ok && title != "" {
+			page.Name = title
+		}
+		for _, p := range properties.Properties {
+			if p.Key == "alias" {
+				page.Aliases = append(page.Aliases, p.Values...)
+			}
+		}
+	}
+	return page
+}
+
+// pageProperties returns the block holding the properties of the page: the pre-block, or a first block made up of
+// nothing but properties.
+func pageProperties(d document.Document) *document.Block {
+	if len(d.Blocks) == 0 {
+		return nil
+	}
+	first := d.Blocks[0]
+	if !first.Bullet || (len(first.Properties) > 0 && len(first.Properties) == len(first.Content)) {
+		return first
+	}
+	return nil
+}
+
+func isGraphFile(p string) bool {
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".md", ".markdown", ".org":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,218 @@
+package graph
+
+import (
+	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/logseq"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	root := t.TempDir()
+	write := func(name, contents string) {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("pages/Some Page.md", "alias:: Other, [[Third Name]]\n\n- a block\n  id:: 63c5db9e-768b-4d81-965e-240b4f69e4e0\n")
+	write("pages/ns___child.md", "- child page\n")
+	write("pages/file name.md", "title:: Real Title\n- text\n")
+	write("journals/2023_01_02.md", "- journal\n")
+	write("pages/ns___other___deep.md", "- deeper page\n")
+	write("journals/notes.txt", "ignored\n")
+
+	c := logseq.DefaultConfig()
+	c.FileNameFormat = "triple-lowbar"
+	ix := New(root, WithConfig(c))
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{
+		"some page":     "pages/Some Page.md",
+		"OTHER":         "pages/Some Page.md",
+		"third name":    "pages/Some Page.md",
+		"ns/child":      "pages/ns___child.md",
+		"Real Title":    "pages/file name.md",
+		"Jan 2nd, 2023": "journals/2023_01_02.md",
+	} {
+		page, ok := ix.Page(name)
+		if !ok {
+			t.Errorf("page %q not found", name)
+			continue
+		}
+		if page.Path != filepath.Join(root, want) {
+			t.Errorf("page %q path = %s, want %s", name, page.Path, want)
+		}
+	}
+	if _, ok := ix.Page("file name"); ok {
+		t.Error("page with a title:: property found by its file name")
+	}
+	b, ok := ix.Block("63C5DB9E-768B-4D81-965E-240B4F69E4E0")
+	if !ok || b.Page != "Some Page" || b.Range.Start.Line != 2 {
+		t.Errorf("block = %+v, %v", b, ok)
+	}
+	var texts []string
+	ix.WalkBlocks(func(b Block) bool {
+		if strings.HasSuffix(b.URI, "Some%20Page.md") {
+			texts = append(texts, b.Text)
+		}
+		return true
+	})
+	if strings.Join(texts, ",") != "a block" {
+		t.Errorf("block texts = %q", texts)
+	}
+	var found []string
+	ix.SearchBlocks([]string{"PAGE", "chi"}, func(b Block) bool {
+		found = append(found, b.Text)
+		return true
+	})
+	if strings.Join(found, ",") != "child page" {
+		t.Errorf("searched blocks = %q", found)
+	}
+	if len(ix.Pages()) != 5 {
+		t.Errorf("indexed %d pages, want 5", len(ix.Pages()))
+	}
+	if children := strings.Join(ix.Children("NS"), ","); children != "ns/child,ns/other" {
+		t.Errorf("children = %s", children)
+	}
+	if keys := ix.PropertyKeys(); keys["alias"] != 1 || keys["title"] != 1 || keys["id"] != 0 {
+		t.Errorf("property keys = %v", keys)
+	}
+	if values := ix.PropertyValues("Alias"); len(values) != 2 || values["Other"] != 1 || values["[[Third Name]]"] != 1 {
+		t.Errorf("alias values = %v", values)
+	}
+	if values := ix.PropertyValues("title"); len(values) != 1 || values["Real Title"] != 1 {
+		t.Errorf("title values = %v", values)
+	}
+
+	ix.Remove(filepath.Join(root, "pages/Some Page.md"))
+	if _, ok := ix.Page("other"); ok {
+		t.Error("alias still indexed after its page was removed")
+	}
+	if _, ok := ix.Block("63c5db9e-768b-4d81-965e-240b4f69e4e0"); ok {
+		t.Error("block still indexed after its page was removed")
+	}
+	if _, ok := ix.PropertyKeys()["alias"]; ok {
+		t.Error("properties still indexed after their page was removed")
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "pages", "new page.md")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	ix := New(root)
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("alias:: fresh\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ix.Page("fresh"); !ok {
+		t.Error("created page not indexed")
+	}
+	if err := os.WriteFile(p, []byte("alias:: fresh\n- [[Linked]] #linked #[[Other Tag]]\n  id:: 63c5db9e-768b-4d81-965e-240b4f69e4e0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(p); err != nil {
+		t.Fatal(err)
+	}
+	// the page's own alias:: property is not a use of the alias
+	if ix.Usage("LINKED") != 2 || ix.Usage("other tag") != 1 || ix.Usage("id") != 0 || ix.Usage("fresh") != 0 {
+		t.Errorf("usage = %d, %d, %d, %d", ix.Usage("linked"), ix.Usage("other tag"), ix.Usage("id"), ix.Usage("fresh"))
+	}
+	if names := strings.Join(ix.ReferencedNames(), ","); names != "Linked,Other Tag" {
+		t.Errorf("referenced names = %s", names)
+	}
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Refresh(p); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ix.Page("new page"); ok {
+		t.Error("deleted page still indexed")
+	}
+	if ix.Usage("linked") != 0 {
+		t.Error("links of a deleted page still counted")
+	}
+	if ix.Contains(filepath.Join(root, "logseq", "config.edn")) || ix.Contains(filepath.Join(root, "pages", "image.png")) {
+		t.Error("non graph files are part of the index")
+	}
+}
+
+func TestReferences(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "pages"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, contents := range map[string]string{
+		"target.md": "alias:: other\n\n- text\n",
+		"linker.md": "- [[Other]] and #[[target]]\n  type:: [[unrelated]]\n- {{embed [[TARGET]]}}\n",
+	} {
+		if err := os.WriteFile(filepath.Join(root, "pages", name), []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := New(root)
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, l := range ix.References("other") {
+		got = append(got, fmt.Sprintf("%s:%d:%d", filepath.Base(l.URI), l.Range.Start.Line, l.Range.Start.Character))
+	}
+	// the alias:: value is a link to the page as well
+	if strings.Join(got, ",") != "linker.md:0:2,linker.md:0:16,linker.md:2:10,target.md:0:8" {
+		t.Errorf("references = %v", got)
+	}
+	if refs := ix.References("type"); len(refs) != 1 {
+		t.Errorf("property key references = %v", refs)
+	}
+	if page, ok := ix.PageForURI(ix.References("unrelated")[0].URI); !ok || page.Name != "linker" {
+		t.Errorf("page for uri = %v, %v", page, ok)
+	}
+}
+
+func TestPropertyKeyCase(t *testing.T) {
+	root := t.TempDir()
+	p := filepath.Join(root, "pages", "cluster.md")
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	contents := "Title:: Kubernetes\nAlias:: k8s\n\n- setup\n  ID:: 63c5db9e-768b-4d81-965e-240b4f69e4e0\n- Template:: cluster\n"
+	if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ix := New(root)
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	if page, ok := ix.Page("K8S"); !ok || page.Name != "Kubernetes" {
+		t.Errorf("page of the alias = %+v, %v", page, ok)
+	}
+	if b, ok := ix.Block("63c5db9e-768b-4d81-965e-240b4f69e4e0"); !ok || b.Text != "setup" {
+		t.Errorf("block = %+v, %v", b, ok)
+	}
+	var templates []string
+	ix.WalkBlocks(func(b Block) bool {
+		if b.Template != "" {
+			templates = append(templates, b.Template)
+		}
+		return true
+	})
+	if strings.Join(templates, ",") != "cluster" {
+		t.Errorf("templates = %v", templates)
+	}
+}
@@ -0,0 +1,134 @@
+package graph
+
+import (
+	"errors"
+	"github.com/fsnotify/fsnotify"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Refresh brings the index up to date with the file at p after it was created, changed or deleted. Paths outside the
+// pages and journals directories are ignored.
+func (ix *Index) Refresh(p string) error {
+	if !ix.Contains(p) {
+		return nil
+	}
+	if _, err := os.Stat(p); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			ix.Remove(p)
+			return nil
+		}
+		return err
+	}
+	return ix.Update(p)
+}
+
+// Contains reports whether p is a page or journal file of the graph.
+func (ix *Index) Contains(p string) bool {
+	if !isGraphFile(p) {
+		return false
+	}
+	for _, dir := range ix.directories() {
+		if rel, err := filepath.Rel(dir, p); err == nil && !strings.HasPrefix(rel, "..") {
+			return true
+		}
+	}
+	return false
+}
+
+func (ix *Index) directories() []string {
+	return []string{filepath.Join(ix.root, ix.config.PagesDirectory), filepath.Join(ix.root, ix.config.JournalsDirectory)}
+}
+
+// Watch follows changes other programs make to the pages and journals directories and refreshes the index until the
+// returned stop function is called. Errors refreshing a file are passed to onError.
+func (ix *Index) Watch(onError func(error)) (stop func() error, err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// fsnotify does not watch sub directories, so every directory of the tree is added. The files of a directory
+	// created after the index was built are indexed as well.
+	addTree := func(root string, refresh bool) {
+		err := filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if entry.IsDir() {
+				return watcher.Add(p)
+			}
+			if refresh {
+				if err := ix.Refresh(p); err != nil {
+					onError(err)
+				}
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			onError(err)
+		}
+	}
+	for _, dir := range ix.directories() {
+		addTree(dir, false)
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op.Has(fsnotify.Create) {
+					if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+						addTree(event.Name, true)
+						continue
+					}
+				}
+				if err := ix.Refresh(event.Name); err != nil {
+					onError(err)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			}
+		}
+	}()
+	return watcher.Close, nil
+}
+
+// WatchFile calls onChange whenever the file at p is written, created, replaced or removed until the returned stop function is
+// called. The directory of p is watched rather than the file, as editors often save by renaming a new file over the
+// old one. Errors of the watcher are passed to onError.
+func WatchFile(p string, onChange func(), onError func(error)) (stop func() error, err error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(p)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == filepath.Clean(p) && event.Op != fsnotify.Chmod {
+					onChange()
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				onError(err)
+			}
+		}
+	}()
+	return watcher.Close, nil
+}
@@ -0,0 +1,112 @@
+package graph
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	root := t.TempDir()
+	pages := filepath.Join(root, "pages")
+	if err := os.MkdirAll(pages, 0755); err != nil {
+		t.Fatal(err)
+	}
+	ix := New(root)
+	if err := ix.Build(); err != nil {
+		t.Fatal(err)
+	}
+	stop, err := ix.Watch(func(err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// waitFor polls the index as the watcher refreshes it in the background
+	waitFor := func(what string, condition func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if condition() {
+				return
+			}
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+	exists := func(name string) func() bool {
+		return func() bool {
+			_, ok := ix.Page(name)
+			return ok
+		}
+	}
+
+	p := filepath.Join(pages, "written.md")
+	if err := os.WriteFile(p, []byte("alias:: first\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a created file to be indexed", exists("first"))
+	if err := os.WriteFile(p, []byte("alias:: second\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a changed file to be indexed", exists("second"))
+	if err := os.Remove(p); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a deleted file to be removed", func() bool {
+		return !exists("written")()
+	})
+
+	// files of a directory created after the watch started are indexed as well
+	dir := filepath.Join(pages, "nested")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "deep.md"), []byte("- text\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("a file in a new directory to be indexed", exists("deep"))
+}
+
+func TestWatchFile(t *testing.T) {
+	dir := t.TempDir()
+	p := filepath.Join(dir, "config.edn")
+	changes := make(chan struct{}, 16)
+	stop, err := WatchFile(p, func() {
+		changes <- struct{}{}
+	}, func(err error) {
+		t.Error(err)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	wait := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "other.edn"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wait("the file to be created")
+	// saving by renaming a new file over the old one is a change as well
+	for len(changes) > 0 {
+		<-changes
+	}
+	tmp := filepath.Join(dir, "config.edn.tmp")
+	if err := os.WriteFile(tmp, []byte("{:a 1}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, p); err != nil {
+		t.Fatal(err)
+	}
+	wait("the file to be replaced")
+}
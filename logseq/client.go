@@ -4,12 +4,14 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/document"
 	"github.com/WhiskeyJack96/logseqlsp/files"
 	"github.com/go-resty/resty/v2"
 	"golang.org/x/exp/slog"
 	"path"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const IDProperty = "id"
@@ -83,6 +85,58 @@ func (c Client) Query(query string) (Query, error) {
 	return UnmarshalQuery(response.Body())
 }
 
+// DatascriptQuery runs an advanced query through logseq.DB.datascriptQuery. Inputs are passed as their source text,
+// such as ":today", and resolved by logseq. The nested result rows are flattened, entities are returned as blocks and
+// scalar values as blocks whose content is the value.
+func (c Client) DatascriptQuery(query string, inputs ...string) (Query, error) {
+	args := append([]string{query}, inputs...)
+	response, err := c.r.R().SetBody(map[string]any{
+		"method": "logseq.DB.datascriptQuery",
+		"args":   args,
+	}).Post("")
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("error retrieving datascript query: %s", string(response.Body()))
+	}
+	return UnmarshalDatascriptQuery(response.Body())
+}
+
+func UnmarshalDatascriptQuery(data []byte) (Query, error) {
+	var raw any
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	var r Query
+	var flatten func(v any) error
+	flatten = func(v any) error {
+		switch value := v.(type) {
+		case nil:
+		case []any:
+			for _, e := range value {
+				if err := flatten(e); err != nil {
+					return err
+				}
+			}
+		case map[string]any:
+			b, err := json.Marshal(value)
+			if err != nil {
+				return err
+			}
+			block, err := UnmarshalBlock(b)
+			if err != nil {
+				return err
+			}
+			r = append(r, block)
+		default:
+			r = append(r, Block{Content: fmt.Sprint(value)})
+		}
+		return nil
+	}
+	return r, flatten(raw)
+}
+
 type Query []Block
 
 func UnmarshalQuery(data []byte) (Query, error) {
@@ -136,6 +190,7 @@ type Page struct {
 	Journal      bool   `json:"journal?,omitempty"`
 	OriginalName string `json:"originalName"`
 	File         Left   `json:"file"`
+	Format       string `json:"format,omitempty"`
 }
 
 func UnmarshalPage(data []byte) (Page, error) {
@@ -154,18 +209,26 @@ func (r *Page) Marshal() ([]byte, error) {
 	return json.Marshal(r)
 }
 
-func (r *Page) ToURI(base string, journalPath string, pagePath string) (string, error) {
+// ToURI returns the uri of the file the page is stored in, named according to the config.edn settings of the graph at
+// base.
+func (r *Page) ToURI(base string, c Config) (string, error) {
 	if r.IsZero() {
 		return "", ErrInvalidPage
 	}
-	sanitizedName := strings.Replace(r.OriginalName, "/", "___", -1)
-
-	fileName := fmt.Sprintf("%s.md", sanitizedName)
-	subFolder := pagePath
+	format := document.Format(r.Format)
+	if format == "" {
+		format = document.Format(c.PreferredFormat)
+	}
+	extension := format.Extension()
+	fileName := fmt.Sprintf("%s.%s", c.PageFileName(r.OriginalName), extension)
+	subFolder := c.PagesDirectory
 	if r.Journal {
-		dateString := strconv.FormatInt(r.JournalDay, 10)
-		fileName = fmt.Sprintf("%s_%s_%s.md", dateString[0:4], dateString[4:6], dateString[6:])
-		subFolder = journalPath
+		day, err := time.ParseInLocation("20060102", strconv.FormatInt(r.JournalDay, 10), time.Local)
+		if err != nil {
+			return "", fmt.Errorf("invalid journal day %d: %w", r.JournalDay, err)
+		}
+		fileName = fmt.Sprintf("%s.%s", c.JournalFileNameFormat.Format(day), extension)
+		subFolder = c.JournalsDirectory
 	}
 	return files.PathToFileURI(path.Join(base, subFolder, fileName)), nil
 }
@@ -211,6 +274,72 @@ func (c Client) GetPageById(id int64) (Page, error) {
 	return UnmarshalPage(response.Body())
 }
 
+// GetPagesFromNamespace returns the pages directly inside the namespace name.
+func (c Client) GetPagesFromNamespace(name string) ([]Page, error) {
+	response, err := c.r.R().SetBody(map[string]any{
+		"method": "logseq.Editor.getPagesFromNamespace",
+		"args":   []string{name},
+	}).Post("")
+	if err != nil {
+		return nil, err
+	}
+	if response.IsError() {
+		return nil, fmt.Errorf("error retrieving namespace pages: %s", string(response.Body()))
+	}
+	var pages []Page
+	err = json.Unmarshal(response.Body(), &pages)
+	return pages, err
+}
+
+// GetAliasedPage returns the page that lists the page named alias in its alias:: property.
+func (c Client) GetAliasedPage(alias string) (Page, error) {
+	query := fmt.Sprintf("[:find (pull ?p [*]) . :where [?a :block/name %s] [?p :block/alias ?a] [?p :block/file]]", ednString(strings.ToLower(alias)))
+	response, err := c.r.R().SetBody(map[string]any{
+		"method": "logseq.DB.datascriptQuery",
+		"args":   []string{query},
+	}).Post("")
+	if err != nil {
+		return Page{}, err
+	}
+	if response.IsError() {
+		return Page{}, fmt.Errorf("error retrieving aliased page: %s", string(response.Body()))
+	}
+	if len(response.Body()) == 0 || string(response.Body()) == "null" {
+		return Page{}, ErrInvalidPage
+	}
+	return UnmarshalPage(response.Body())
+}
+
+// ednString quotes s as an edn string literal.
+func ednString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+var ErrFileNotFound = errors.New("file not found")
+
+// GetFilePath returns the path logseq stores the file entity with the given id under. Depending on the logseq version
+// the path is absolute or relative to the graph directory.
+func (c Client) GetFilePath(id int64) (string, error) {
+	response, err := c.r.R().SetBody(map[string]any{
+		"method": "logseq.DB.datascriptQuery",
+		"args":   []string{fmt.Sprintf("[:find ?path . :where [%d :file/path ?path]]", id)},
+	}).Post("")
+	if err != nil {
+		return "", err
+	}
+	if response.IsError() {
+		return "", fmt.Errorf("error retrieving file: %s", string(response.Body()))
+	}
+	var p *string
+	if err := json.Unmarshal(response.Body(), &p); err != nil {
+		return "", err
+	}
+	if p == nil || *p == "" {
+		return "", ErrFileNotFound
+	}
+	return *p, nil
+}
+
 func (c Client) GetPageByName(name string) (Page, error) {
 	response, err := c.r.R().SetBody(map[string]any{
 		"method": "logseq.App.getPage",
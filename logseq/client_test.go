@@ -0,0 +1,125 @@
+package logseq
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// apiRequest is the body of a call to the logseq http api.
+type apiRequest struct {
+	Method string   `json:"method"`
+	Args   []string `json:"args"`
+}
+
+// testClient returns a client of a server that answers every api call with the status and body respond returns.
+func testClient(t *testing.T, respond func(r apiRequest) (int, string)) Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var request apiRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Error(err)
+		}
+		status, body := respond(request)
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	c, err := NewClient(nil, WithBaseUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestGetFilePath(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr error
+	}{
+		{name: "found", status: http.StatusOK, body: `"pages/a.md"`, want: "pages/a.md"},
+		{name: "no file", status: http.StatusOK, body: `null`, wantErr: ErrFileNotFound},
+		{name: "empty path", status: http.StatusOK, body: `""`, wantErr: ErrFileNotFound},
+		{name: "error response", status: http.StatusInternalServerError, body: `{"error": "boom"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testClient(t, func(r apiRequest) (int, string) {
+				if r.Method != "logseq.DB.datascriptQuery" || len(r.Args) != 1 || !strings.Contains(r.Args[0], "[12 :file/path ?path]") {
+					t.Errorf("unexpected request %+v", r)
+				}
+				return test.status, test.body
+			})
+			got, err := c.GetFilePath(12)
+			if test.status != http.StatusOK {
+				if err == nil {
+					t.Errorf("GetFilePath = %q, want an error", got)
+				}
+				return
+			}
+			if !errors.Is(err, test.wantErr) || got != test.want {
+				t.Errorf("GetFilePath = %q, %v, want %q, %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestGetAliasedPage(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr error
+	}{
+		{name: "found", status: http.StatusOK, body: `{"originalName": "Real Page", "name": "real page", "file": {"id": 7}}`, want: "Real Page"},
+		{name: "not found", status: http.StatusOK, body: `null`, wantErr: ErrInvalidPage},
+		{name: "error response", status: http.StatusInternalServerError, body: `{"error": "boom"}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := testClient(t, func(r apiRequest) (int, string) {
+				if r.Method != "logseq.DB.datascriptQuery" || len(r.Args) != 1 || !strings.Contains(r.Args[0], `[?a :block/name "my \"alias\""]`) {
+					t.Errorf("unexpected request %+v", r)
+				}
+				return test.status, test.body
+			})
+			got, err := c.GetAliasedPage(`My "Alias"`)
+			if test.status != http.StatusOK {
+				if err == nil {
+					t.Errorf("GetAliasedPage = %+v, want an error", got)
+				}
+				return
+			}
+			if !errors.Is(err, test.wantErr) || got.OriginalName != test.want {
+				t.Errorf("GetAliasedPage = %+v, %v, want %q, %v", got, err, test.want, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestToURIExtension(t *testing.T) {
+	org := DefaultConfig()
+	org.PreferredFormat = "org"
+	for _, tt := range []struct {
+		page   Page
+		config Config
+		want   string
+	}{
+		{Page{OriginalName: "a"}, DefaultConfig(), "file:///graph/pages/a.md"},
+		{Page{OriginalName: "a"}, org, "file:///graph/pages/a.org"},
+		{Page{OriginalName: "a", Format: "markdown"}, org, "file:///graph/pages/a.md"},
+		{Page{OriginalName: "a", Format: "org"}, DefaultConfig(), "file:///graph/pages/a.org"},
+	} {
+		got, err := tt.page.ToURI("/graph", tt.config)
+		if err != nil || got != tt.want {
+			t.Errorf("ToURI of %+v with format %q = %s, %v, want %s", tt.page, tt.config.PreferredFormat, got, err, tt.want)
+		}
+	}
+}
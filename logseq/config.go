@@ -0,0 +1,113 @@
+package logseq
+
+import (
+	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/edn"
+	"os"
+	"path"
+	"strings"
+)
+
+// Config holds the settings of a graph's logseq/config.edn that the language server uses.
+type Config struct {
+	// PagesDirectory and JournalsDirectory are relative to the graph
+	PagesDirectory    string
+	JournalsDirectory string
+	// JournalFileNameFormat names journal files, without their extension
+	JournalFileNameFormat DateFormat
+	// JournalPageTitleFormat names journal pages
+	JournalPageTitleFormat DateFormat
+	// FileNameFormat is the :file/name-format, "triple-lowbar" or "legacy", that page names are escaped with in file
+	// names
+	FileNameFormat string
+	// PreferredFormat is "markdown" or "org", the format new pages are written in
+	PreferredFormat string
+	// SeparatedByCommas lists the properties whose plain comma separated values are page references
+	SeparatedByCommas []string
+	// Macros maps the name of a user macro to its template
+	Macros map[string]string
+}
+
+// DefaultConfig returns the settings logseq uses when config.edn does not override them.
+func DefaultConfig() Config {
+	return Config{
+		PagesDirectory:         "pages",
+		JournalsDirectory:      "journals",
+		JournalFileNameFormat:  "yyyy_MM_dd",
+		JournalPageTitleFormat: "MMM do, yyyy",
+		FileNameFormat:         "legacy",
+		PreferredFormat:        "markdown",
+		Macros:                 map[string]string{},
+	}
+}
+
+// ReadConfig reads logseq/config.edn from the graph directory. The defaults are returned along with any error.
+func ReadConfig(graphPath string) (Config, error) {
+	src, err := os.ReadFile(path.Join(graphPath, "logseq", "config.edn"))
+	if err != nil {
+		return DefaultConfig(), err
+	}
+	return ParseConfig(string(src))
+}
+
+// ParseConfig reads the settings from the source of a config.edn, falling back to the defaults for missing keys.
+func ParseConfig(src string) (Config, error) {
+	c := DefaultConfig()
+	root, err := edn.ParseOne(src)
+	if err != nil {
+		return c, fmt.Errorf("error parsing config.edn: %w", err)
+	}
+	if root.Kind != edn.Map {
+		return c, fmt.Errorf("error parsing config.edn: expected a map, got %s", root.Kind)
+	}
+	setString := func(key string, value *string) {
+		if n := root.Get(key); n != nil && (n.Kind == edn.String || n.Kind == edn.Keyword) && n.Value != "" {
+			*value = strings.TrimPrefix(n.Value, ":")
+		}
+	}
+	setString(":pages-directory", &c.PagesDirectory)
+	setString(":journals-directory", &c.JournalsDirectory)
+	setString(":file/name-format", &c.FileNameFormat)
+	setString(":preferred-format", &c.PreferredFormat)
+	c.PreferredFormat = strings.ToLower(c.PreferredFormat)
+	var fileNameFormat, titleFormat string
+	setString(":journal/file-name-format", &fileNameFormat)
+	setString(":journal/page-title-format", &titleFormat)
+	if fileNameFormat != "" {
+		c.JournalFileNameFormat = DateFormat(fileNameFormat)
+	}
+	if titleFormat != "" {
+		c.JournalPageTitleFormat = DateFormat(titleFormat)
+	}
+	if separated := root.Get(":property/separated-by-commas"); separated != nil {
+		for _, key := range separated.Strings() {
+			c.SeparatedByCommas = append(c.SeparatedByCommas, strings.TrimPrefix(key, ":"))
+		}
+	}
+	if macros := root.Get(":macros"); macros != nil && macros.Kind == edn.Map {
+		for i := 0; i+1 < len(macros.Children); i += 2 {
+			name, template := macros.Children[i], macros.Children[i+1]
+			if template.Kind == edn.String {
+				c.Macros[strings.TrimPrefix(name.Value, ":")] = template.Value
+			}
+		}
+	}
+	return c, nil
+}
+
+// PageFileName returns the name, without extension, of the file logseq writes the page to in the :file/name-format
+// of the graph.
+func (c Config) PageFileName(name string) string {
+	if c.FileNameFormat == "triple-lowbar" {
+		return tripleLowbarFileName(name)
+	}
+	return legacyFileName(name)
+}
+
+// FilePageName returns the name of the page stored in a file, base being the file name without its extension.
+func (c Config) FilePageName(base string) string {
+	if c.FileNameFormat == "triple-lowbar" {
+		return tripleLowbarPageName(base)
+	}
+	return legacyPageName(base)
+}
@@ -0,0 +1,58 @@
+package logseq
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig(`{:meta/version 1
+ ;; comment
+ :preferred-format "Org"
+ :pages-directory "notes"
+ :journal/page-title-format "EEE, dd.MM.yyyy"
+ :journal/file-name-format "yyyy-MM-dd"
+ :file/name-format :triple-lowbar
+ :property/separated-by-commas #{:related}
+ :macros {"poem" "Rose is $1"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.PreferredFormat != "org" || c.PagesDirectory != "notes" || c.JournalsDirectory != "journals" || c.FileNameFormat != "triple-lowbar" {
+		t.Errorf("config = %+v", c)
+	}
+	if c.JournalPageTitleFormat != "EEE, dd.MM.yyyy" || c.JournalFileNameFormat != "yyyy-MM-dd" {
+		t.Errorf("journal formats = %q %q", c.JournalPageTitleFormat, c.JournalFileNameFormat)
+	}
+	if len(c.SeparatedByCommas) != 1 || c.SeparatedByCommas[0] != "related" || c.Macros["poem"] != "Rose is $1" {
+		t.Errorf("config = %+v", c)
+	}
+}
+
+func TestDateFormat(t *testing.T) {
+	date := time.Date(2023, time.January, 22, 0, 0, 0, 0, time.Local)
+	for format, want := range map[DateFormat]string{
+		"MMM do, yyyy":     "Jan 22nd, 2023",
+		"yyyy_MM_dd":       "2023_01_22",
+		"EEEE, MM/dd/yyyy": "Sunday, 01/22/2023",
+		"E, dd-MM-yyyy":    "Sun, 22-01-2023",
+		"do MMMM yyyy":     "22nd January 2023",
+		"yyyyMMdd":         "20230122",
+		"yyyy年MM月dd日":      "2023年01月22日",
+		"'week' d M yy":    "week 22 1 23",
+	} {
+		if got := format.Format(date); got != want {
+			t.Errorf("%q.Format = %q, want %q", format, got, want)
+		}
+		parsed, err := format.Parse(want)
+		if err != nil || !parsed.Equal(date) {
+			t.Errorf("%q.Parse(%q) = %v, %v", format, want, parsed, err)
+		}
+	}
+	if _, err := DateFormat("yyyy_MM_dd").Parse("2023_02_30"); err == nil {
+		t.Error("parsed an invalid date")
+	}
+	if _, err := DateFormat("MMM do, yyyy").Parse("jan 1st, 2023"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,177 @@
+package logseq
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DateFormat is a date-fns style pattern such as "MMM do, yyyy", the syntax of :journal/page-title-format and
+// :journal/file-name-format. Text in single quotes is copied as is.
+type DateFormat string
+
+// dateTokens are the pattern letters logseq offers in its journal formats, longest first so they match greedily.
+var dateTokens = []string{"yyyy", "yy", "MMMM", "MMM", "MM", "M", "do", "dd", "d", "EEEE", "EEE", "EE", "E"}
+
+type dateToken struct {
+	// pattern is empty for literal text
+	pattern string
+	literal string
+}
+
+func (f DateFormat) tokens() []dateToken {
+	var tokens []dateToken
+	s := string(f)
+	for len(s) > 0 {
+		if s[0] == '\'' {
+			end := strings.IndexByte(s[1:], '\'')
+			switch end {
+			case -1:
+				tokens, s = append(tokens, dateToken{literal: s[1:]}), ""
+			case 0:
+				// '' is an escaped quote
+				tokens, s = append(tokens, dateToken{literal: "'"}), s[2:]
+			default:
+				tokens, s = append(tokens, dateToken{literal: s[1 : end+1]}), s[end+2:]
+			}
+			continue
+		}
+		matched := false
+		for _, t := range dateTokens {
+			if strings.HasPrefix(s, t) {
+				tokens = append(tokens, dateToken{pattern: t})
+				s = s[len(t):]
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			tokens = append(tokens, dateToken{literal: s[:1]})
+			s = s[1:]
+		}
+	}
+	return tokens
+}
+
+// Format renders date in the format.
+func (f DateFormat) Format(date time.Time) string {
+	var b strings.Builder
+	for _, t := range f.tokens() {
+		switch t.pattern {
+		case "":
+			b.WriteString(t.literal)
+		case "yyyy":
+			b.WriteString(date.Format("2006"))
+		case "yy":
+			b.WriteString(date.Format("06"))
+		case "MMMM":
+			b.WriteString(date.Format("January"))
+		case "MMM":
+			b.WriteString(date.Format("Jan"))
+		case "MM":
+			b.WriteString(date.Format("01"))
+		case "M":
+			b.WriteString(date.Format("1"))
+		case "do":
+			b.WriteString(ordinal(date.Day()))
+		case "dd":
+			b.WriteString(date.Format("02"))
+		case "d":
+			b.WriteString(date.Format("2"))
+		case "EEEE":
+			b.WriteString(date.Format("Monday"))
+		default:
+			b.WriteString(date.Format("Mon"))
+		}
+	}
+	return b.String()
+}
+
+// Parse reads a date written in the format, ignoring case. Day names are accepted but not checked against the date.
+func (f DateFormat) Parse(s string) (time.Time, error) {
+	var pattern strings.Builder
+	pattern.WriteString("(?i)^")
+	var fields []string
+	for _, t := range f.tokens() {
+		switch t.pattern {
+		case "":
+			pattern.WriteString(regexp.QuoteMeta(t.literal))
+			continue
+		case "yyyy":
+			pattern.WriteString(`(\d{4})`)
+		case "yy", "MM", "dd":
+			pattern.WriteString(`(\d{2})`)
+		case "M", "d":
+			pattern.WriteString(`(\d{1,2})`)
+		case "do":
+			pattern.WriteString(`(\d{1,2})(?:st|nd|rd|th)`)
+		case "MMMM", "MMM":
+			pattern.WriteString(`([[:alpha:]]+)`)
+		default:
+			pattern.WriteString(`[[:alpha:]]+`)
+			continue
+		}
+		fields = append(fields, t.pattern)
+	}
+	pattern.WriteString("$")
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return time.Time{}, err
+	}
+	match := re.FindStringSubmatch(s)
+	if match == nil {
+		return time.Time{}, fmt.Errorf("%q does not match date format %q", s, f)
+	}
+	year, month, day := 0, time.Month(0), 0
+	for i, field := range fields {
+		value := match[i+1]
+		switch field {
+		case "yyyy":
+			year, _ = strconv.Atoi(value)
+		case "yy":
+			year, _ = strconv.Atoi(value)
+			year += 2000
+		case "MM", "M":
+			m, _ := strconv.Atoi(value)
+			month = time.Month(m)
+		case "MMMM", "MMM":
+			month = parseMonth(value)
+		default:
+			day, _ = strconv.Atoi(value)
+		}
+	}
+	if month < time.January || month > time.December || day < 1 || day > 31 {
+		return time.Time{}, fmt.Errorf("%q is not a valid date for format %q", s, f)
+	}
+	date := time.Date(year, month, day, 0, 0, 0, 0, time.Local)
+	if date.Day() != day {
+		return time.Time{}, fmt.Errorf("%q is not a valid date for format %q", s, f)
+	}
+	return date, nil
+}
+
+func parseMonth(name string) time.Month {
+	for m := time.January; m <= time.December; m++ {
+		full := m.String()
+		if strings.EqualFold(name, full) || strings.EqualFold(name, full[:3]) {
+			return m
+		}
+	}
+	return 0
+}
+
+func ordinal(day int) string {
+	suffix := "th"
+	switch {
+	case day%100 >= 11 && day%100 <= 13:
+	case day%10 == 1:
+		suffix = "st"
+	case day%10 == 2:
+		suffix = "nd"
+	case day%10 == 3:
+		suffix = "rd"
+	}
+	return strconv.Itoa(day) + suffix
+}
@@ -0,0 +1,96 @@
+package logseq
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// These mirror the file name sanitisation logseq applies when it writes a page, so the file of a page can be found
+// from its name and the name of a page from its file without asking logseq.
+
+var reservedFileCharsRegex = regexp.MustCompile(`[:*?"<>|#\\]+`)
+var urlEncodedRegex = regexp.MustCompile(`(?i)%[0-9a-f]{2}`)
+var multipleSlashesRegex = regexp.MustCompile(`/{2,}`)
+
+// windowsReservedFileBodies are names windows does not allow as file names whatever their extension.
+var windowsReservedFileBodies = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// sanitizePageName trims the whitespace and the leading and trailing slashes logseq drops from page names and
+// collapses repeated slashes.
+func sanitizePageName(name string) string {
+	name = strings.Trim(strings.TrimSpace(name), "/")
+	return multipleSlashesRegex.ReplaceAllString(name, "/")
+}
+
+// tripleLowbarFileName encodes a page name in the :triple-lowbar format: namespace slashes become "___", lowbars that
+// would make that ambiguous and the characters file systems reserve are percent-encoded, and a literal percent-encoded
+// sequence in the name has its % encoded so it survives decoding.
+func tripleLowbarFileName(name string) string {
+	name = sanitizePageName(name)
+	name = urlEncodedRegex.ReplaceAllStringFunc(name, escapePercent)
+	if windowsReservedFileBodies[name] || strings.HasSuffix(name, ".") {
+		// the trailing slash becomes a trailing "___" and is dropped again when the name is read back
+		name += "/"
+	}
+	name = strings.ReplaceAll(name, "___", "%5F%5F%5F")
+	name = strings.ReplaceAll(name, "_/", "%5F/")
+	name = strings.ReplaceAll(name, "/_", "/%5F")
+	name = strings.ReplaceAll(name, "/", "___")
+	return reservedFileCharsRegex.ReplaceAllStringFunc(name, encodeURIComponent)
+}
+
+// tripleLowbarPageName decodes the body of a file name written in the :triple-lowbar format.
+func tripleLowbarPageName(body string) string {
+	return sanitizePageName(unescapePercent(strings.ReplaceAll(body, "___", "/")))
+}
+
+// legacyFileName encodes a page name in the legacy format, where namespace slashes become dots and literal dots and
+// reserved characters are percent-encoded.
+func legacyFileName(name string) string {
+	name = sanitizePageName(name)
+	name = urlEncodedRegex.ReplaceAllStringFunc(name, escapePercent)
+	name = reservedFileCharsRegex.ReplaceAllStringFunc(name, encodeURIComponent)
+	name = strings.ReplaceAll(name, ".", "%2E")
+	return strings.ReplaceAll(name, "/", ".")
+}
+
+// legacyPageName decodes the body of a file name written in the legacy format.
+func legacyPageName(body string) string {
+	return sanitizePageName(unescapePercent(strings.ReplaceAll(body, ".", "/")))
+}
+
+func escapePercent(encoded string) string {
+	return "%25" + encoded[1:]
+}
+
+// encodeURIComponent percent-encodes s the way javascript's encodeURIComponent does.
+func encodeURIComponent(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("-_.!~*'()", c) != -1 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// unescapePercent decodes every valid %XX sequence of s and leaves a stray % as it is, unlike url.PathUnescape which
+// rejects the whole string.
+func unescapePercent(s string) string {
+	return urlEncodedRegex.ReplaceAllStringFunc(s, func(encoded string) string {
+		c, err := strconv.ParseUint(encoded[1:], 16, 8)
+		if err != nil {
+			return encoded
+		}
+		return string([]byte{byte(c)})
+	})
+}
@@ -0,0 +1,62 @@
+package logseq
+
+import "testing"
+
+// fileNameCorpus pairs page names with the file names, without extension, logseq writes them to in the
+// :triple-lowbar and legacy formats.
+var fileNameCorpus = []struct {
+	name         string
+	tripleLowbar string
+	legacy       string
+}{
+	{"simple page", "simple page", "simple page"},
+	{"Mixed Case", "Mixed Case", "Mixed Case"},
+	{"ns/child", "ns___child", "ns.child"},
+	{"a/b/c", "a___b___c", "a.b.c"},
+	{"what?", "what%3F", "what%3F"},
+	{"time: 10:30", "time%3A 10%3A30", "time%3A 10%3A30"},
+	{"c#", "c%23", "c%23"},
+	{"100%", "100%", "100%"},
+	{"literal %41", "literal %2541", "literal %2541"},
+	{"quote\"d <tag>", "quote%22d %3Ctag%3E", "quote%22d %3Ctag%3E"},
+	{"pipe|back\\slash", "pipe%7Cback%5Cslash", "pipe%7Cback%5Cslash"},
+	{"v1.2 notes", "v1.2 notes", "v1%2E2 notes"},
+	{"snake_case", "snake_case", "snake_case"},
+	{"a___b", "a%5F%5F%5Fb", "a___b"},
+	{"a_/b", "a%5F___b", "a_.b"},
+	{"a/_b", "a___%5Fb", "a._b"},
+	{"CON", "CON___", "CON"},
+	{"ends with.", "ends with.___", "ends with%2E"},
+	{"日本語/ページ", "日本語___ページ", "日本語.ページ"},
+	{"emoji 🚀", "emoji 🚀", "emoji 🚀"},
+}
+
+func TestFileNameRoundTrip(t *testing.T) {
+	for _, c := range []Config{{FileNameFormat: "triple-lowbar"}, {FileNameFormat: "legacy"}} {
+		for _, tc := range fileNameCorpus {
+			want := tc.legacy
+			if c.FileNameFormat == "triple-lowbar" {
+				want = tc.tripleLowbar
+			}
+			if got := c.PageFileName(tc.name); got != want {
+				t.Errorf("%s PageFileName(%q) = %q, want %q", c.FileNameFormat, tc.name, got, want)
+			}
+			if got := c.FilePageName(want); got != tc.name {
+				t.Errorf("%s FilePageName(%q) = %q, want %q", c.FileNameFormat, want, got, tc.name)
+			}
+		}
+	}
+}
+
+func TestFilePageNameSanitizes(t *testing.T) {
+	c := Config{FileNameFormat: "triple-lowbar"}
+	for body, want := range map[string]string{
+		"___leading":    "leading",
+		"a______b":      "a/b",
+		"bad%zz escape": "bad%zz escape",
+	} {
+		if got := c.FilePageName(body); got != want {
+			t.Errorf("FilePageName(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/WhiskeyJack96/logseqlsp/document"
 	"github.com/WhiskeyJack96/logseqlsp/files"
+	"github.com/WhiskeyJack96/logseqlsp/graph"
 	"github.com/WhiskeyJack96/logseqlsp/logseq"
 	"github.com/spf13/cobra"
 	"github.com/tliron/glsp"
@@ -12,34 +14,71 @@ import (
 	"github.com/tliron/glsp/server"
 	"golang.org/x/exp/slog"
 	"io"
+	"net/url"
 	"os"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const lsName = "logSeq"
 
 var version = "0.0.1"
 
-// TODO get pages/journals path from the logseq api or make them config values
 type graphInfo struct {
 	name string
 	path string
 
-	pagesPath    string
-	journalsPath string
-
 	client  logseq.Client
 	logger  *slog.Logger
 	handler protocol.Handler
 	config  config
+
+	// encoding is the position encoding negotiated with the client during initialize
+	encoding document.PositionEncoding
+	// documents holds the buffers of the documents open in the client
+	documents *document.Store
+	// loaded is replaced when config.edn changes while handlers, the file watchers and the background indexing read it,
+	// so the config and the index are published together through an atomic pointer
+	loaded atomic.Pointer[loadedGraph]
+	// watchedFiles is true when the client can register file watchers that keep the index up to date
+	watchedFiles bool
+	// snippets is true when the client accepts completions with snippet placeholders
+	snippets bool
+	// loading serializes loadGraph, which runs on initialize and again whenever config.edn changes
+	loading sync.Mutex
+	// stopWatching stops the filesystem watcher of the graph's files for the --watch flag, which is replaced along with
+	// the index. stopWatchingConfig stops the watcher of config.edn, which lasts as long as the server.
+	stopWatching       func() error
+	stopWatchingConfig func() error
+}
+
+// loadedGraph is the graph's logseq/config.edn, which decides where pages are stored and how their files are named, and
+// the index that resolves pages and blocks from the graph's files when the logseq api is not available.
+type loadedGraph struct {
+	config logseq.Config
+	index  *graph.Index
+}
+
+// graphConfig returns the settings of the loaded graph.
+func (gi *graphInfo) graphConfig() logseq.Config {
+	return gi.loaded.Load().config
+}
+
+// index returns the index of the loaded graph.
+func (gi *graphInfo) index() *graph.Index {
+	return gi.loaded.Load().index
 }
 
 type config struct {
-	logging bool
-	port    int32
-	token   string
-	logFile string
+	logging  bool
+	port     int32
+	token    string
+	logFile  string
+	graph    string
+	watch    bool
+	cacheDir string
 }
 
 func main() {
@@ -55,6 +94,9 @@ func main() {
 	}
 	root.Flags().String("log-file", path.Join(userHomeDir, ".config/logseqlsp/log.json"), "file to log too defaults to (~/.config/logseqlsp/log.json)")
 	root.Flags().Int32P("port", "p", 12315, "port logseq is listening on")
+	root.Flags().StringP("graph", "g", "", "path to the graph directory, defaults to the current logseq graph or the workspace root")
+	root.Flags().String("cache-dir", path.Join(userHomeDir, ".config/logseqlsp/cache"), "directory the graph index is cached in between sessions, empty to disable the cache")
+	root.Flags().BoolP("watch", "w", false, "watch the graph directory for changes made outside the editor instead of relying on the client")
 
 	err = root.Execute()
 	if err != nil {
@@ -79,6 +121,19 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
+	graphPath, err := cmd.Flags().GetString("graph")
+	if err != nil {
+		return err
+	}
+	watch, err := cmd.Flags().GetBool("watch")
+	if err != nil {
+		return err
+	}
+	cacheDir, err := cmd.Flags().GetString("cache-dir")
+	if err != nil {
+		return err
+	}
+
 	logger, err := newLogger(logging, logFile)
 	if err != nil {
 		return err
@@ -95,44 +150,43 @@ func run(cmd *cobra.Command, args []string) error {
 		return err
 	}
 
-	graph, err := client.CurrentGraph()
-	if err != nil {
-		return err
+	var name string
+	if graphPath == "" {
+		current, err := client.CurrentGraph()
+		if err != nil {
+			logger.Warn("could not get the current graph, the graph will be read from the workspace root", slog.Any("err", err))
+		}
+		name, graphPath = current.Name, current.Path
 	}
 
 	info := graphInfo{
-		name:         graph.Name,
-		path:         graph.Path,
-		pagesPath:    "pages",
-		journalsPath: "journals",
-		client:       client,
-		logger:       logger,
+		name:      name,
+		path:      graphPath,
+		client:    client,
+		logger:    logger,
+		encoding:  document.UTF16,
+		documents: document.NewStore(),
 		config: config{
-			logging: logging,
-			port:    port,
-			token:   token,
-			logFile: logFile,
+			logging:  logging,
+			port:     port,
+			token:    token,
+			logFile:  logFile,
+			graph:    graphPath,
+			watch:    watch,
+			cacheDir: cacheDir,
 		},
 	}
 
-	info.handler = protocol.Handler{
-		Initialize:  info.initialize,
-		Initialized: info.initialized,
-		Shutdown:    info.shutdown,
-		SetTrace:    info.setTrace,
-		TextDocumentDidOpen: func(context *glsp.Context, params *protocol.DidOpenTextDocumentParams) error {
-			info.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
+	info.loaded.Store(&loadedGraph{config: logseq.DefaultConfig(), index: graph.New("")})
 
-			return nil
-		},
-		TextDocumentDidChange: func(context *glsp.Context, params *protocol.DidChangeTextDocumentParams) error {
-			info.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
-			return nil
-		},
-		TextDocumentDidClose: func(context *glsp.Context, params *protocol.DidCloseTextDocumentParams) error {
-			info.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
-			return nil
-		},
+	info.handler = protocol.Handler{
+		Initialize:            info.initialize,
+		Initialized:           info.initialized,
+		Shutdown:              info.shutdown,
+		SetTrace:              info.setTrace,
+		TextDocumentDidOpen:   info.didOpen,
+		TextDocumentDidChange: info.didChange,
+		TextDocumentDidClose:  info.didClose,
 		TextDocumentWillSave: func(context *glsp.Context, params *protocol.WillSaveTextDocumentParams) error {
 			info.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
 			return nil
@@ -145,11 +199,16 @@ func run(cmd *cobra.Command, args []string) error {
 			info.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
 			return nil
 		},
-		TextDocumentHover:             info.hover,
-		TextDocumentDefinition:        info.definition,
-		TextDocumentDocumentHighlight: info.highlight,
-		TextDocumentCodeAction:        info.codeAction,
-		TextDocumentDocumentLink:      info.links,
+		TextDocumentHover:              info.hover,
+		TextDocumentDefinition:         info.definition,
+		TextDocumentDocumentHighlight:  info.highlight,
+		TextDocumentCodeAction:         info.codeAction,
+		TextDocumentDocumentLink:       info.links,
+		TextDocumentCompletion:         info.completion,
+		CompletionItemResolve:          info.completionResolve,
+		WorkspaceDidChangeWatchedFiles: info.didChangeWatchedFiles,
+		WorkspaceExecuteCommand:        info.executeCommand,
+		TextDocumentReferences:         info.references,
 	}
 	logger.Info("serving")
 
@@ -182,13 +241,16 @@ func newLogger(logging bool, logFile string) (*slog.Logger, error) {
 }
 
 func (gi *graphInfo) initialize(context *glsp.Context, params *protocol.InitializeParams) (any, error) {
+	incremental := protocol.TextDocumentSyncKindIncremental
 	capabilities := gi.handler.CreateServerCapabilities()
 	capabilities.CodeActionProvider = true
 	capabilities.DefinitionProvider = true
 	capabilities.HoverProvider = true
 	capabilities.DocumentHighlightProvider = true
+	capabilities.ReferencesProvider = true
 	capabilities.TextDocumentSync = &protocol.TextDocumentSyncOptions{
 		OpenClose:         &protocol.True,
+		Change:            &incremental,
 		WillSave:          &protocol.True,
 		WillSaveWaitUntil: &protocol.True,
 		Save:              &protocol.SaveOptions{IncludeText: &protocol.True},
@@ -196,10 +258,33 @@ func (gi *graphInfo) initialize(context *glsp.Context, params *protocol.Initiali
 	capabilities.DocumentLinkProvider = &protocol.DocumentLinkOptions{
 		ResolveProvider: &protocol.True,
 	}
-	gi.logger.Info("initialize", slog.Any("caps", capabilities), slog.Any("client", params.Capabilities))
+	capabilities.ExecuteCommandProvider = &protocol.ExecuteCommandOptions{
+		Commands: []string{addBlockIDCommand},
+	}
+	capabilities.CompletionProvider = &protocol.CompletionOptions{
+		TriggerCharacters: []string{"[", "#", ":", "(", "/"},
+		ResolveProvider:   &protocol.True,
+	}
+	gi.encoding = negotiatePositionEncoding(context.Params)
+	if gi.path == "" && params.RootURI != nil {
+		if u, err := url.Parse(*params.RootURI); err == nil {
+			gi.path = u.Path
+		}
+	}
+	if workspace := params.Capabilities.Workspace; workspace != nil && workspace.DidChangeWatchedFiles != nil {
+		gi.watchedFiles = workspace.DidChangeWatchedFiles.DynamicRegistration != nil && *workspace.DidChangeWatchedFiles.DynamicRegistration
+	}
+	if textDocument := params.Capabilities.TextDocument; textDocument != nil && textDocument.Completion != nil && textDocument.Completion.CompletionItem != nil {
+		gi.snippets = textDocument.Completion.CompletionItem.SnippetSupport != nil && *textDocument.Completion.CompletionItem.SnippetSupport
+	}
+	gi.loadGraph()
+	gi.logger.Info("initialize", slog.Any("caps", capabilities), slog.Any("client", params.Capabilities), slog.String("positionEncoding", string(gi.encoding)))
 
-	return protocol.InitializeResult{
-		Capabilities: capabilities,
+	return initializeResult{
+		Capabilities: serverCapabilities{
+			ServerCapabilities: capabilities,
+			PositionEncoding:   gi.encoding,
+		},
 		ServerInfo: &protocol.InitializeResultServerInfo{
 			Name:    lsName,
 			Version: &version,
@@ -207,11 +292,158 @@ func (gi *graphInfo) initialize(context *glsp.Context, params *protocol.Initiali
 	}, nil
 }
 
+// loadGraph reads the graph's config.edn and indexes its files.
+func (gi *graphInfo) loadGraph() {
+	gi.loading.Lock()
+	defer gi.loading.Unlock()
+	if gi.path == "" {
+		gi.logger.Warn("no graph path, pages can only be resolved through the logseq api")
+		gi.loaded.Store(&loadedGraph{config: logseq.DefaultConfig(), index: graph.New("")})
+		return
+	}
+	graphConfig, err := logseq.ReadConfig(gi.path)
+	if err != nil {
+		gi.logger.Error("could not read config.edn", err, slog.String("graph", gi.path))
+	}
+	index := graph.New(gi.path,
+		graph.WithConfig(graphConfig),
+		graph.WithEncoding(gi.encoding),
+		graph.WithDocumentOptions(document.WithSeparatedByCommas(graphConfig.SeparatedByCommas...)),
+	)
+	if cache := gi.cachePath(); cache != "" {
+		if err := index.Load(cache); err != nil && !errors.Is(err, os.ErrNotExist) {
+			gi.logger.Warn("could not load index cache", slog.Any("err", err), slog.String("cache", cache))
+		}
+	}
+	// the cached index answers requests while the files that changed since it was written are indexed
+	go func(index *graph.Index) {
+		if err := index.Build(); err != nil {
+			gi.logger.Error("could not index graph", err, slog.String("graph", gi.path))
+		}
+		gi.logger.Info("indexed graph", slog.String("graph", gi.path), slog.Int("pages", len(index.Pages())))
+		gi.saveIndex(index)
+	}(index)
+	gi.loaded.Store(&loadedGraph{config: graphConfig, index: index})
+	if gi.config.watch {
+		if gi.stopWatching != nil {
+			if err := gi.stopWatching(); err != nil {
+				gi.logger.Error("could not stop watching graph", err)
+			}
+		}
+		gi.stopWatching, err = index.Watch(func(err error) {
+			gi.logger.Error("could not refresh graph file", err)
+		})
+		if err != nil {
+			gi.logger.Error("could not watch graph", err, slog.String("graph", gi.path))
+		}
+		if gi.stopWatchingConfig == nil {
+			gi.stopWatchingConfig, err = graph.WatchFile(gi.configPath(), gi.loadGraph, func(err error) {
+				gi.logger.Error("could not watch config.edn", err)
+			})
+			if err != nil {
+				gi.logger.Error("could not watch config.edn", err, slog.String("config", gi.configPath()))
+			}
+		}
+	}
+}
+
+// initializeResult and serverCapabilities add the LSP 3.17 positionEncoding capability which protocol_3_16 lacks
+type initializeResult struct {
+	Capabilities serverCapabilities                   `json:"capabilities"`
+	ServerInfo   *protocol.InitializeResultServerInfo `json:"serverInfo,omitempty"`
+}
+
+type serverCapabilities struct {
+	protocol.ServerCapabilities
+	PositionEncoding document.PositionEncoding `json:"positionEncoding,omitempty"`
+}
+
+// negotiatePositionEncoding reads general.positionEncodings from the raw initialize params since protocol_3_16 does
+// not decode it. Clients that do not send it only support utf-16.
+func negotiatePositionEncoding(raw json.RawMessage) document.PositionEncoding {
+	var params struct {
+		Capabilities struct {
+			General struct {
+				PositionEncodings []string `json:"positionEncodings"`
+			} `json:"general"`
+		} `json:"capabilities"`
+	}
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return document.UTF16
+	}
+	return document.ParsePositionEncoding(params.Capabilities.General.PositionEncodings)
+}
+
 func (gi *graphInfo) initialized(context *glsp.Context, params *protocol.InitializedParams) error {
+	if gi.watchedFiles && gi.path != "" {
+		// registerCapability is a request to the client, waiting for its response inside a handler would block the
+		// connection the response arrives on
+		go gi.registerFileWatchers(context)
+	}
 	return nil
 }
 
+// registerFileWatchers asks the client to report changes to the graph's pages, journals and config.edn.
+func (gi *graphInfo) registerFileWatchers(context *glsp.Context) {
+	var watchers []protocol.FileSystemWatcher
+	for _, dir := range []string{gi.graphConfig().PagesDirectory, gi.graphConfig().JournalsDirectory} {
+		watchers = append(watchers, protocol.FileSystemWatcher{GlobPattern: path.Join(gi.path, dir) + "/**/*.{md,markdown,org}"})
+	}
+	watchers = append(watchers, protocol.FileSystemWatcher{GlobPattern: gi.configPath()})
+	context.Call(protocol.ServerClientRegisterCapability, protocol.RegistrationParams{
+		Registrations: []protocol.Registration{{
+			ID:              "logseqlsp-watched-files",
+			Method:          string(protocol.MethodWorkspaceDidChangeWatchedFiles),
+			RegisterOptions: protocol.DidChangeWatchedFilesRegistrationOptions{Watchers: watchers},
+		}},
+	}, nil)
+	gi.logger.Info("registered file watchers", slog.Any("watchers", watchers))
+}
+
+// didChangeWatchedFiles refreshes the index for graph files changed outside the editor, and reloads the whole graph
+// when config.edn changes.
+func (gi *graphInfo) didChangeWatchedFiles(context *glsp.Context, params *protocol.DidChangeWatchedFilesParams) error {
+	for _, change := range params.Changes {
+		u, err := url.Parse(change.URI)
+		if err != nil {
+			gi.logger.Error("invalid watched file uri", err, slog.String("uri", change.URI))
+			continue
+		}
+		if u.Path == gi.configPath() {
+			gi.loadGraph()
+			continue
+		}
+		if err := gi.index().Refresh(u.Path); err != nil {
+			gi.logger.Error("could not refresh graph file", err, slog.String("uri", change.URI))
+		}
+	}
+	return nil
+}
+
+// cachePath returns the file the index of the graph is cached in, or an empty string when caching is disabled.
+func (gi *graphInfo) cachePath() string {
+	if gi.config.cacheDir == "" || gi.path == "" {
+		return ""
+	}
+	return graph.CachePath(gi.config.cacheDir, gi.path)
+}
+
+func (gi *graphInfo) saveIndex(index *graph.Index) {
+	cache := gi.cachePath()
+	if cache == "" {
+		return
+	}
+	if err := index.Save(cache); err != nil {
+		gi.logger.Error("could not save index cache", err, slog.String("cache", cache))
+	}
+}
+
+func (gi *graphInfo) configPath() string {
+	return path.Join(gi.path, "logseq", "config.edn")
+}
+
 func (gi *graphInfo) shutdown(context *glsp.Context) error {
+	gi.saveIndex(gi.index())
 	protocol.SetTraceValue(protocol.TraceValueOff)
 	return nil
 }
@@ -221,6 +453,102 @@ func (gi *graphInfo) setTrace(context *glsp.Context, params *protocol.SetTracePa
 	return nil
 }
 
+func (gi *graphInfo) didOpen(context *glsp.Context, params *protocol.DidOpenTextDocumentParams) error {
+	gi.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
+	d, err := gi.documents.Open(params.TextDocument.URI, params.TextDocument.Text, gi.documentOptions(params.TextDocument.URI)...)
+	if err != nil {
+		return err
+	}
+	gi.publishDiagnostics(context, params.TextDocument.URI, d)
+	return nil
+}
+
+func (gi *graphInfo) didChange(context *glsp.Context, params *protocol.DidChangeTextDocumentParams) error {
+	gi.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
+	d, err := gi.documents.Change(params.TextDocument.URI, params.ContentChanges)
+	if err != nil {
+		return err
+	}
+	gi.publishDiagnostics(context, params.TextDocument.URI, d)
+	return nil
+}
+
+func (gi *graphInfo) didClose(context *glsp.Context, params *protocol.DidCloseTextDocumentParams) error {
+	gi.logger.Info(context.Method, slog.String("file", params.TextDocument.URI))
+	gi.documents.Close(params.TextDocument.URI)
+	context.Notify(protocol.ServerTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
+		URI:         params.TextDocument.URI,
+		Diagnostics: []protocol.Diagnostic{},
+	})
+	return nil
+}
+
+// publishDiagnostics reports the problems found in an open document, replacing the ones previously sent for it.
+func (gi *graphInfo) publishDiagnostics(context *glsp.Context, uri protocol.DocumentUri, d document.Document) {
+	diagnostics := d.MacroDiagnostics(gi.graphConfig().Macros)
+	if diagnostics == nil {
+		diagnostics = []protocol.Diagnostic{}
+	}
+	context.Notify(protocol.ServerTextDocumentPublishDiagnostics, protocol.PublishDiagnosticsParams{
+		URI:         uri,
+		Diagnostics: diagnostics,
+	})
+}
+
+// addBlockIDCommand gives a block in another file the id a completed ((uuid)) reference to it was written with. Its
+// arguments are the uri of the file, the line the block starts on and the id.
+const addBlockIDCommand = "logseqlsp.addBlockId"
+
+func (gi *graphInfo) executeCommand(context *glsp.Context, params *protocol.ExecuteCommandParams) (any, error) {
+	switch params.Command {
+	case addBlockIDCommand:
+		if len(params.Arguments) != 3 {
+			return nil, fmt.Errorf("%s expects 3 arguments, got %d", params.Command, len(params.Arguments))
+		}
+		uri, uriOK := params.Arguments[0].(string)
+		line, lineOK := params.Arguments[1].(float64)
+		id, idOK := params.Arguments[2].(string)
+		if !uriOK || !lineOK || !idOK {
+			return nil, fmt.Errorf("%s expects a uri, line and id, got %v", params.Command, params.Arguments)
+		}
+		return nil, gi.addBlockID(context, uri, protocol.UInteger(line), id)
+	}
+	return nil, fmt.Errorf("unknown command %s", params.Command)
+}
+
+// addBlockID asks the client to add the id:: property to the block starting on line of the document at uri, unless
+// the block already has one.
+func (gi *graphInfo) addBlockID(context *glsp.Context, uri protocol.DocumentUri, line protocol.UInteger, id string) error {
+	d, err := gi.readDocument(uri)
+	if err != nil {
+		return err
+	}
+	var edit *protocol.TextEdit
+	d.Walk(func(b *document.Block) bool {
+		if b.Bullet && b.Range.Start.Line == line {
+			if existing, ok := b.Property("id"); ok && existing != "" {
+				gi.logger.Warn("block already has an id", slog.String("uri", uri), slog.String("id", existing))
+			} else {
+				e := d.IDEdit(b, id)
+				edit = &e
+			}
+			return false
+		}
+		return true
+	})
+	if edit == nil {
+		return nil
+	}
+	label := "Add block id"
+	// applyEdit is a request to the client, waiting for its response inside a handler would block the connection the
+	// response arrives on
+	go context.Call(protocol.ServerWorkspaceApplyEdit, protocol.ApplyWorkspaceEditParams{
+		Label: &label,
+		Edit:  protocol.WorkspaceEdit{Changes: map[protocol.DocumentUri][]protocol.TextEdit{uri: {*edit}}},
+	}, nil)
+	return nil
+}
+
 func (gi *graphInfo) codeAction(context *glsp.Context, params *protocol.CodeActionParams) (interface{}, error) {
 	gi.logger.Info("code action fired", params.Range)
 	return nil, nil
@@ -228,7 +556,7 @@ func (gi *graphInfo) codeAction(context *glsp.Context, params *protocol.CodeActi
 
 func (gi *graphInfo) definition(context *glsp.Context, params *protocol.DefinitionParams) (interface{}, error) {
 	gi.logger.Info("definition", slog.String("uri", params.TextDocument.URI), slog.Any("position", params.Position))
-	d, err := readDocumentIdentifier(params.TextDocument)
+	d, err := gi.readDocument(params.TextDocument.URI)
 	if err != nil {
 		return nil, err
 	}
@@ -241,10 +569,25 @@ func (gi *graphInfo) definition(context *glsp.Context, params *protocol.Definiti
 		return nil, err
 	}
 
+	if l.Type == document.External || l.Type == document.Macro {
+		return nil, nil
+	}
+	if l.Type == document.BlockEmbed {
+		if b, ok := gi.index().Block(l.Target); ok {
+			return &protocol.Location{URI: b.URI, Range: b.Range}, nil
+		}
+	}
+	if isPageLink(l) {
+		// a position on a namespace segment leads to that parent page
+		l.Target = d.NamespaceAt(l, params.Position)
+	}
 	s, err := gi.linkToURI(l)
 	if err != nil {
 		return nil, err
 	}
+	if s == nil {
+		return nil, nil
+	}
 	gi.logger.Info("found link uri", slog.Any("uri", s))
 
 	return &protocol.Location{
@@ -261,28 +604,39 @@ func (gi *graphInfo) definition(context *glsp.Context, params *protocol.Definiti
 }
 
 func (gi *graphInfo) links(ctx *glsp.Context, params *protocol.DocumentLinkParams) ([]protocol.DocumentLink, error) {
-	d, err := readDocumentIdentifier(params.TextDocument)
+	d, err := gi.readDocument(params.TextDocument.URI)
 	if err != nil {
 		return nil, err
 	}
 	var dlinks []protocol.DocumentLink
 	for _, link := range d.Links {
+		if link.Type == document.Macro {
+			continue
+		}
 		uri, err := gi.linkToURI(link)
 		if err != nil {
-			return nil, err
+			gi.logger.Error("skipping unresolved document link", err, slog.Any("link", link))
+			continue
 		}
-		dlinks = append(dlinks, protocol.DocumentLink{
+		if uri == nil {
+			continue
+		}
+		dlink := protocol.DocumentLink{
 			Range:  link.Range,
 			Target: uri,
-			//Tooltip: nil,
-		})
+		}
+		if link.Label != "" {
+			tooltip := link.Label
+			dlink.Tooltip = &tooltip
+		}
+		dlinks = append(dlinks, dlink)
 	}
 	return dlinks, nil
 }
 
 func (gi *graphInfo) hover(context *glsp.Context, params *protocol.HoverParams) (*protocol.Hover, error) {
 	gi.logger.Info("hover", slog.Any("params", params))
-	d, err := readDocumentIdentifier(params.TextDocument)
+	d, err := gi.readDocument(params.TextDocument.URI)
 	if err != nil {
 		return nil, err
 	}
@@ -294,8 +648,33 @@ func (gi *graphInfo) hover(context *glsp.Context, params *protocol.HoverParams)
 		return nil, err
 	}
 	switch l.Type {
-	case document.Wiki, document.Tag, document.Prop:
-		hoverDoc, err := gi.linkToDocument(l)
+	case document.Wiki, document.Tag, document.Prop, document.PropValue:
+		if l.Target == "" {
+			return nil, nil
+		}
+		target := l.Target
+		l.Target = d.NamespaceAt(l, params.Position)
+		// a position on a parent segment of the link, such as a in [[a/b]], is on a namespace
+		children := gi.namespaceChildren(l.Target, l.Target != target)
+		var childList string
+		if len(children) > 0 {
+			childList = "\n\n---\n\nNamespace pages:\n"
+			for _, child := range children {
+				childList = childList + "- [[" + child + "]]\n"
+			}
+		}
+		page, err := gi.resolvePage(l.Target)
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			if childList != "" {
+				// namespaces often have no page of their own
+				return &protocol.Hover{Contents: "**" + l.Target + "**" + childList, Range: &l.Range}, nil
+			}
+			return nil, nil
+		}
+		hoverDoc, err := gi.readDocument(page.URI)
 		if err != nil {
 			gi.logger.Error("could not find file", err, slog.Any("link", l))
 			if errors.Is(err, os.ErrNotExist) {
@@ -303,25 +682,68 @@ func (gi *graphInfo) hover(context *glsp.Context, params *protocol.HoverParams)
 			}
 			return nil, err
 		}
-		return &protocol.Hover{Contents: hoverDoc.Contents, Range: &l.Range}, nil
+		contents := hoverDoc.Contents
+		if page.Name != "" && !strings.EqualFold(page.Name, l.Target) {
+			contents = fmt.Sprintf("*%s* is an alias of **%s**\n\n---\n\n%s", l.Target, page.Name, contents)
+		} else if len(page.Aliases) > 0 {
+			contents = fmt.Sprintf("Aliases: %s\n\n---\n\n%s", strings.Join(page.Aliases, ", "), contents)
+		}
+		return &protocol.Hover{Contents: contents + childList, Range: &l.Range}, nil
 	case document.Query:
+		if l.Datalog != nil {
+			if l.Datalog.Query == "" {
+				contents := protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: "The advanced query has no `:query`"}
+				if l.Datalog.Title != "" {
+					contents.Value = "**" + l.Datalog.Title + "**\n\n" + contents.Value
+				}
+				return &protocol.Hover{Contents: contents, Range: &l.Range}, nil
+			}
+			response, err := gi.client.DatascriptQuery(l.Datalog.Query, l.Datalog.Inputs...)
+			if err != nil {
+				return nil, err
+			}
+			contents := gi.queryToMarkup(response)
+			if l.Datalog.Title != "" {
+				contents.Value = "**" + l.Datalog.Title + "**\n\n" + contents.Value
+			}
+			return &protocol.Hover{Contents: contents, Range: &l.Range}, nil
+		}
 		response, err := gi.client.Query(l.Target)
 		if err != nil {
 			return nil, err
 		}
 		return &protocol.Hover{Contents: gi.queryToMarkup(response), Range: &l.Range}, nil
 	case document.BlockEmbed:
+		if b, ok := gi.index().Block(l.Target); ok {
+			if d, err := gi.readDocument(b.URI); err == nil {
+				if block, err := d.FindBlockForPosition(b.Range.Start); err == nil {
+					return &protocol.Hover{Contents: documentBlockToMarkup(block), Range: &l.Range}, nil
+				}
+			}
+		}
 		response, err := gi.client.GetBlock(l.Target)
 		if err != nil {
 			return nil, err
 		}
 		return &protocol.Hover{Contents: gi.blockToMarkup(response), Range: &l.Range}, nil
+	case document.Asset, document.External:
+		target := l.Target
+		if uri, err := gi.linkToURI(l); err == nil && uri != nil {
+			target = *uri
+		}
+		contents := protocol.MarkupContent{Kind: protocol.MarkupKindMarkdown, Value: target}
+		if l.Label != "" {
+			contents.Value = l.Label + " → " + target
+		}
+		return &protocol.Hover{Contents: contents, Range: &l.Range}, nil
+	case document.Macro:
+		return &protocol.Hover{Contents: gi.macroToMarkup(l), Range: &l.Range}, nil
 	}
 	return nil, nil
 }
 
 func (gi *graphInfo) highlight(context *glsp.Context, params *protocol.DocumentHighlightParams) ([]protocol.DocumentHighlight, error) {
-	d, err := readDocumentIdentifier(params.TextDocument)
+	d, err := gi.readDocument(params.TextDocument.URI)
 	if err != nil {
 		return nil, err
 	}
@@ -335,13 +757,13 @@ func (gi *graphInfo) highlight(context *glsp.Context, params *protocol.DocumentH
 		return nil, err
 	}
 	kindText := protocol.DocumentHighlightKindText
-	primaryLink = link.Target
+	primaryLink = gi.linkKey(link)
 	highlights = append(highlights, protocol.DocumentHighlight{
 		Range: link.Range,
 		Kind:  &kindText,
 	})
 	for _, l := range d.Links {
-		if l.Target == primaryLink && !positionInRange(d.Contents, l.Range, params.Position) {
+		if gi.linkKey(l) == primaryLink && !d.RangeContains(l.Range, params.Position) {
 			highlights = append(highlights, protocol.DocumentHighlight{
 				Range: l.Range,
 				Kind:  &kindText,
@@ -351,32 +773,48 @@ func (gi *graphInfo) highlight(context *glsp.Context, params *protocol.DocumentH
 	return highlights, nil
 }
 
-func readDocumentIdentifier(td protocol.TextDocumentIdentifier) (document.Document, error) {
-	readCloser, err := files.URIToReader(td.URI)
+// readDocument returns the open buffer for uri, falling back to the file on disk when the client does not have it open.
+func (gi *graphInfo) readDocument(uri protocol.DocumentUri) (document.Document, error) {
+	if d, ok := gi.documents.Get(uri); ok {
+		return d, nil
+	}
+	readCloser, err := files.URIToReader(uri)
 	if err != nil {
 		return document.Document{}, err
 	}
 	defer readCloser.Close()
-	d, err := document.New(readCloser)
+	d, err := document.New(readCloser, gi.documentOptions(uri)...)
 	if err != nil {
 		return document.Document{}, err
 	}
 	return d, nil
 }
 
+// documentOptions returns the options to parse the document at uri with.
+func (gi *graphInfo) documentOptions(uri protocol.DocumentUri) []document.Option {
+	return []document.Option{
+		document.WithEncoding(gi.encoding),
+		document.WithFormat(document.FormatForPath(uri)),
+		document.WithSeparatedByCommas(gi.graphConfig().SeparatedByCommas...),
+	}
+}
+
 func (gi *graphInfo) linkToURI(l document.Link) (*protocol.DocumentUri, error) {
 	var page logseq.Page
 	switch l.Type {
-	case document.Wiki, document.Tag, document.Prop:
+	case document.Wiki, document.Tag, document.Prop, document.PropValue:
 		if l.Target == "" {
 			return nil, nil
 		}
-		var err error
-		page, err = gi.client.GetPageByName(l.Target)
-		if err != nil {
+		resolved, err := gi.resolvePage(l.Target)
+		if err != nil || resolved == nil {
 			return nil, err
 		}
+		return &resolved.URI, nil
 	case document.BlockEmbed:
+		if indexed, ok := gi.index().Block(l.Target); ok {
+			return &indexed.URI, nil
+		}
 		block, err := gi.client.GetBlock(l.Target)
 		if err != nil {
 			gi.logger.Error("error in linkToUri", err, slog.Any("link", l))
@@ -388,11 +826,16 @@ func (gi *graphInfo) linkToURI(l document.Link) (*protocol.DocumentUri, error) {
 			return nil, fmt.Errorf("error calling getPage: %w", err)
 		}
 		gi.logger.Info("found page", slog.Any("page", page), slog.Any("block", block.Page.ID))
+	case document.External:
+		return &l.Target, nil
+	case document.Asset:
+		uri := gi.assetToURI(l.Target)
+		return &uri, nil
 	default:
 		gi.logger.Error("error in linkToUri", fmt.Errorf("unsupported link type: %s", l.Type))
 		return nil, fmt.Errorf("unsupported link type: %s", l.Type)
 	}
-	uri, err := page.ToURI(gi.path, gi.journalsPath, gi.pagesPath)
+	uri, err := gi.pageToURI(page)
 	if err != nil {
 		gi.logger.Error("error converting page to URI", err)
 		return nil, err
@@ -400,6 +843,158 @@ func (gi *graphInfo) linkToURI(l document.Link) (*protocol.DocumentUri, error) {
 	return &uri, nil
 }
 
+// resolvedPage is the page a page link leads to once aliases have been followed.
+type resolvedPage struct {
+	URI  protocol.DocumentUri
+	Name string
+	// Aliases are the alias:: names of the page, only known for pages in the index
+	Aliases []string
+}
+
+// resolvePage finds the page named name, or the page that declares name in its alias:: property, first in the index
+// and then through the logseq api. It returns nil when neither knows the page, including when the api cannot be
+// reached, as links to pages that do not exist yet are common.
+func (gi *graphInfo) resolvePage(name string) (*resolvedPage, error) {
+	if indexed, ok := gi.index().Page(name); ok {
+		return &resolvedPage{URI: indexed.URI, Name: indexed.Name, Aliases: indexed.Aliases}, nil
+	}
+	page, err := gi.client.GetPageByName(name)
+	if err != nil {
+		gi.logger.Debug("page not found", slog.Any("err", err), slog.String("page", name))
+		return nil, nil
+	}
+	if page.File.ID == 0 {
+		// alias pages have no file of their own
+		if aliased, err := gi.client.GetAliasedPage(name); err == nil {
+			page = aliased
+		}
+	}
+	uri, err := gi.pageToURI(page)
+	if err != nil {
+		return nil, err
+	}
+	if page.OriginalName == "" {
+		return &resolvedPage{URI: uri, Name: page.Name}, nil
+	}
+	return &resolvedPage{URI: uri, Name: page.OriginalName}, nil
+}
+
+// namespaceChildren lists the pages directly inside the namespace name from the index. The logseq api is only asked
+// when there is no index of the graph, or when name is known to be a namespace and the index has none of its pages.
+func (gi *graphInfo) namespaceChildren(name string, namespace bool) []string {
+	children := gi.index().Children(name)
+	if len(children) > 0 || (gi.path != "" && !namespace) {
+		return children
+	}
+	pages, err := gi.client.GetPagesFromNamespace(name)
+	if err != nil {
+		gi.logger.Warn("could not get namespace pages", slog.Any("err", err), slog.String("namespace", name))
+		return nil
+	}
+	for _, page := range pages {
+		children = append(children, page.OriginalName)
+	}
+	return children
+}
+
+// references finds every link in the graph to the page under the cursor, or to the page of the document when the
+// cursor is not on a page link. Open documents are searched as they are in the editor, the rest of the graph as the
+// index last read it.
+func (gi *graphInfo) references(context *glsp.Context, params *protocol.ReferenceParams) ([]protocol.Location, error) {
+	d, err := gi.readDocument(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	var name string
+	link, err := d.FindLinkForPosition(params.Position)
+	switch {
+	case err == nil && isPageLink(link):
+		name = d.NamespaceAt(link, params.Position)
+	case err == nil:
+		return nil, nil
+	case errors.Is(err, document.ErrLinkNotFound):
+		page, ok := gi.index().PageForURI(params.TextDocument.URI)
+		if !ok {
+			return nil, nil
+		}
+		name = page.Name
+	default:
+		return nil, err
+	}
+	open := gi.documents.Documents()
+	locations := []protocol.Location{}
+	for _, l := range gi.index().References(name) {
+		if _, ok := open[l.URI]; !ok {
+			locations = append(locations, l)
+		}
+	}
+	key := gi.pageKey(name)
+	for uri, od := range open {
+		for _, r := range graph.DocumentRefs(od) {
+			if gi.pageKey(r.Name) == key {
+				locations = append(locations, protocol.Location{URI: uri, Range: r.Range})
+			}
+		}
+	}
+	graph.SortLocations(locations)
+	// the declaration of a page is the start of its file
+	if page, ok := gi.index().Page(name); ok && params.Context.IncludeDeclaration {
+		locations = append([]protocol.Location{{URI: page.URI}}, locations...)
+	}
+	return locations, nil
+}
+
+// isPageLink reports whether the target of a link is a page name.
+func isPageLink(l document.Link) bool {
+	switch l.Type {
+	case document.Wiki, document.Tag, document.Prop, document.PropValue:
+		return true
+	}
+	return false
+}
+
+// linkKey identifies what a link refers to, so links to the same page through different aliases or casing compare
+// equal.
+func (gi *graphInfo) linkKey(l document.Link) string {
+	if !isPageLink(l) {
+		return l.Target
+	}
+	return gi.pageKey(l.Target)
+}
+
+// pageKey identifies the page name refers to, following aliases.
+func (gi *graphInfo) pageKey(name string) string {
+	if page, ok := gi.index().Page(name); ok {
+		return graph.NormalizeName(page.Name)
+	}
+	return graph.NormalizeName(name)
+}
+
+// pageToURI returns the uri of the file logseq stores a page in. The path is taken from the page's file entity, and
+// only guessed from the page name when the page does not have a file yet or logseq cannot say where it is.
+func (gi *graphInfo) pageToURI(page logseq.Page) (protocol.DocumentUri, error) {
+	if page.File.ID != 0 {
+		p, err := gi.client.GetFilePath(page.File.ID)
+		if err == nil {
+			if !path.IsAbs(p) {
+				p = path.Join(gi.path, p)
+			}
+			return files.PathToFileURI(p), nil
+		}
+		gi.logger.Warn("could not get page file, guessing it from the page name", slog.Any("err", err), slog.Int64("file", page.File.ID))
+	}
+	return page.ToURI(gi.path, gi.graphConfig())
+}
+
+// assetToURI resolves the target of an Asset link. Relative paths are written relative to the page files, which all
+// sit one directory below the graph, so they are resolved against the pages directory.
+func (gi *graphInfo) assetToURI(target string) protocol.DocumentUri {
+	if !path.IsAbs(target) {
+		target = path.Join(gi.path, gi.graphConfig().PagesDirectory, target)
+	}
+	return files.PathToFileURI(target)
+}
+
 func (gi *graphInfo) queryToMarkup(response logseq.Query) protocol.MarkupContent {
 	s := protocol.MarkupContent{
 		Kind:  protocol.MarkupKindMarkdown,
@@ -411,6 +1006,22 @@ func (gi *graphInfo) queryToMarkup(response logseq.Query) protocol.MarkupContent
 	return s
 }
 
+// macroToMarkup shows the text a user macro expands to, or what a built-in macro does.
+func (gi *graphInfo) macroToMarkup(l document.Link) protocol.MarkupContent {
+	s := protocol.MarkupContent{
+		Kind:  protocol.MarkupKindMarkdown,
+		Value: "**" + l.Target + "**\n\n",
+	}
+	if template, ok := gi.graphConfig().Macros[l.Target]; ok {
+		s.Value = s.Value + document.ExpandMacro(template, l.Args) + "\n"
+	} else if spec, ok := document.BuiltinMacros[l.Target]; ok {
+		s.Value = s.Value + spec.Description + "\n"
+	} else {
+		s.Value = s.Value + "unknown macro\n"
+	}
+	return s
+}
+
 func (gi *graphInfo) blockToMarkup(response logseq.Block) protocol.MarkupContent {
 	s := protocol.MarkupContent{
 		Kind:  protocol.MarkupKindMarkdown,
@@ -422,26 +1033,17 @@ func (gi *graphInfo) blockToMarkup(response logseq.Block) protocol.MarkupContent
 	return s
 }
 
-func (gi *graphInfo) linkToDocument(l document.Link) (document.Document, error) {
-	uri, err := gi.linkToURI(l)
-	if err != nil {
-		return document.Document{}, err
-	}
-	file, err := files.URIToReader(*uri)
-	if err != nil {
-		return document.Document{}, err
+// documentBlockToMarkup renders a block read from the graph's files the way blockToMarkup renders one from the api,
+// leaving out its property lines.
+func documentBlockToMarkup(b *document.Block) protocol.MarkupContent {
+	s := protocol.MarkupContent{
+		Kind:  protocol.MarkupKindMarkdown,
+		Value: "- " + strings.Join(b.Text(), "\n  ") + "\n",
 	}
-	defer file.Close()
-	doc, err := document.New(file)
-	if err != nil {
-		return document.Document{}, err
+	for _, c := range b.Children {
+		if text := c.Text(); len(text) > 0 {
+			s.Value = s.Value + "\t- " + text[0] + "\n"
+		}
 	}
-	return doc, nil
-}
-
-func positionInRange(content string, rng protocol.Range, pos protocol.Position) bool {
-	start, end := rng.IndexesIn(content)
-	i := pos.IndexIn(content)
-	//logger.Info("indexes", slog.Int("start", start), slog.Int("end", end), slog.Int("i", i))
-	return i >= start && i <= end
+	return s
 }
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	"github.com/WhiskeyJack96/logseqlsp/files"
+	"github.com/WhiskeyJack96/logseqlsp/graph"
+	"github.com/WhiskeyJack96/logseqlsp/logseq"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"golang.org/x/exp/slog"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestGraph writes files, keyed by their path relative to the graph directory, to a temporary graph and returns a
+// graphInfo serving it with its index built.
+func newTestGraph(t *testing.T, pages map[string]string) *graphInfo {
+	t.Helper()
+	root := t.TempDir()
+	for name, contents := range pages {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	index := graph.New(root)
+	if err := index.Build(); err != nil {
+		t.Fatal(err)
+	}
+	gi := &graphInfo{
+		path:      root,
+		logger:    slog.New(slog.NewJSONHandler(io.Discard)),
+		encoding:  document.UTF16,
+		documents: document.NewStore(),
+		snippets:  true,
+	}
+	gi.loaded.Store(&loadedGraph{config: logseq.DefaultConfig(), index: index})
+	return gi
+}
+
+// uri returns the uri of the file at name, relative to the graph directory.
+func (gi *graphInfo) uri(name string) protocol.DocumentUri {
+	return files.PathToFileURI(filepath.Join(gi.path, filepath.FromSlash(name)))
+}
+
+// serveAPI points the logseq client of gi at a server that answers the api with handler.
+func serveAPI(t *testing.T, gi *graphInfo, handler http.HandlerFunc) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	client, err := logseq.NewClient(gi.logger, logseq.WithBaseUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gi.client = client
+}
+
+// apiCall decodes the method of a logseq api request and its first argument.
+func apiCall(t *testing.T, r *http.Request) (string, string) {
+	t.Helper()
+	var body struct {
+		Method string `json:"method"`
+		Args   []any  `json:"args"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Error(err)
+	}
+	if len(body.Args) == 0 {
+		return body.Method, ""
+	}
+	return body.Method, fmt.Sprint(body.Args[0])
+}
+
+func TestReadDocument(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"pages/a.md": "- on disk\n"})
+	uri := gi.uri("pages/a.md")
+	if _, err := gi.documents.Open(uri, "- in the editor\n"); err != nil {
+		t.Fatal(err)
+	}
+	d, err := gi.readDocument(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Contents != "- in the editor\n" {
+		t.Errorf("open document read as %q", d.Contents)
+	}
+	gi.documents.Close(uri)
+	d, err = gi.readDocument(uri)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Contents != "- on disk\n" {
+		t.Errorf("closed document read as %q, want the file on disk", d.Contents)
+	}
+}
+
+func TestReferences(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{
+		"pages/a.md": "- [[b]]\n",
+		"pages/b.md": "alias:: bee\n",
+		"pages/c.md": "- [[b]] and [[B]]\n",
+	})
+	// the editor's buffer of c.md replaces the links the index read from disk
+	if _, err := gi.documents.Open(gi.uri("pages/c.md"), "- nothing here\n- #bee\n"); err != nil {
+		t.Fatal(err)
+	}
+	locations, err := gi.references(nil, &protocol.ReferenceParams{
+		TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: gi.uri("pages/a.md")},
+			Position:     protocol.Position{Line: 0, Character: 4},
+		},
+		Context: protocol.ReferenceContext{IncludeDeclaration: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		uri  protocol.DocumentUri
+		line protocol.UInteger
+	}{
+		{gi.uri("pages/b.md"), 0},
+		{gi.uri("pages/a.md"), 0},
+		{gi.uri("pages/b.md"), 0},
+		{gi.uri("pages/c.md"), 1},
+	}
+	if len(locations) != len(want) {
+		t.Fatalf("got %d locations %+v, want %d", len(locations), locations, len(want))
+	}
+	for i, w := range want {
+		if locations[i].URI != w.uri || locations[i].Range.Start.Line != w.line {
+			t.Errorf("location %d = %s:%d, want %s:%d", i, locations[i].URI, locations[i].Range.Start.Line, w.uri, w.line)
+		}
+	}
+}
+
+func TestHoverNamespaceChildren(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{
+		"pages/a.md":   "- text\n",
+		"pages/a_b.md": "title:: a/b\n",
+		"pages/c.md":   "- text\n",
+	})
+	namespaceCalls := 0
+	serveAPI(t, gi, func(w http.ResponseWriter, r *http.Request) {
+		if method, _ := apiCall(t, r); method != "logseq.Editor.getPagesFromNamespace" {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+		namespaceCalls++
+		_, _ = w.Write([]byte(`[{"originalName": "n/m"}]`))
+	})
+	uri := gi.uri("pages/d.md")
+	if _, err := gi.documents.Open(uri, "- [[a]] [[c]] [[n/m]]\n"); err != nil {
+		t.Fatal(err)
+	}
+	hover := func(character protocol.UInteger) string {
+		h, err := gi.hover(nil, &protocol.HoverParams{TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+			TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+			Position:     protocol.Position{Character: character},
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h == nil {
+			return ""
+		}
+		return h.Contents.(string)
+	}
+	if got := hover(4); !strings.Contains(got, "- [[a/b]]") {
+		t.Errorf("hover of a = %q, want the indexed namespace page a/b", got)
+	}
+	if got := hover(10); strings.Contains(got, "Namespace pages") {
+		t.Errorf("hover of c = %q, want no namespace pages", got)
+	}
+	if namespaceCalls != 0 {
+		t.Errorf("asked the api for namespace pages the index knows")
+	}
+	// n is a namespace by the link, but the index has none of its pages
+	if got := hover(16); !strings.Contains(got, "- [[n/m]]") {
+		t.Errorf("hover of n = %q, want the namespace pages of the api", got)
+	}
+	if namespaceCalls != 1 {
+		t.Errorf("asked the api for namespace pages %d times, want once", namespaceCalls)
+	}
+}
+
+func TestResolvePageUnreachable(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"pages/a.md": "- text\n"})
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client, err := logseq.NewClient(gi.logger, logseq.WithBaseUrl(server.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gi.client = client
+	page, err := gi.resolvePage("missing")
+	if page != nil || err != nil {
+		t.Errorf("resolvePage = %+v, %v, want nil, nil", page, err)
+	}
+	if page, err := gi.resolvePage("a"); err != nil || page == nil || page.URI != gi.uri("pages/a.md") {
+		t.Errorf("resolvePage of an indexed page = %+v, %v", page, err)
+	}
+}
+
+func TestPageToURI(t *testing.T) {
+	tests := []struct {
+		name    string
+		page    logseq.Page
+		status  int
+		body    string
+		want    string
+		wantErr error
+	}{
+		{name: "relative file path", page: logseq.Page{OriginalName: "A", File: logseq.Left{ID: 3}}, status: http.StatusOK, body: `"pages/file.md"`, want: "pages/file.md"},
+		{name: "absolute file path", page: logseq.Page{OriginalName: "A", File: logseq.Left{ID: 3}}, status: http.StatusOK, body: `"/elsewhere/file.md"`, want: "file:///elsewhere/file.md"},
+		{name: "file not found", page: logseq.Page{OriginalName: "A", File: logseq.Left{ID: 3}}, status: http.StatusOK, body: `null`, want: "pages/A.md"},
+		{name: "error response", page: logseq.Page{OriginalName: "A", File: logseq.Left{ID: 3}}, status: http.StatusInternalServerError, want: "pages/A.md"},
+		{name: "no file", page: logseq.Page{OriginalName: "A", Format: "org"}, want: "pages/A.org"},
+		{name: "no page", wantErr: logseq.ErrInvalidPage},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			gi := newTestGraph(t, nil)
+			serveAPI(t, gi, func(w http.ResponseWriter, r *http.Request) {
+				if test.status == 0 {
+					t.Errorf("asked the api for the file of a page without one")
+				}
+				w.WriteHeader(test.status)
+				_, _ = w.Write([]byte(test.body))
+			})
+			got, err := gi.pageToURI(test.page)
+			if !errors.Is(err, test.wantErr) {
+				t.Fatalf("pageToURI error = %v, want %v", err, test.wantErr)
+			}
+			want := test.want
+			if want != "" && !strings.HasPrefix(want, "file://") {
+				want = gi.uri(want)
+			}
+			if got != want {
+				t.Errorf("pageToURI = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestResolvePage(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"pages/indexed.md": "alias:: other\n"})
+	serveAPI(t, gi, func(w http.ResponseWriter, r *http.Request) {
+		method, arg := apiCall(t, r)
+		var body string
+		switch {
+		case method == "logseq.App.getPage":
+			body = map[string]string{
+				"page":         `{"name": "page", "originalName": "Page", "file": {"id": 3}}`,
+				"alias":        `{"name": "alias", "originalName": "Alias"}`,
+				"orphan alias": `{"name": "orphan alias", "originalName": "Orphan Alias"}`,
+				"missing":      `null`,
+			}[arg]
+		case strings.Contains(arg, ":block/alias"):
+			body = `null`
+			if strings.Contains(arg, `"alias"`) {
+				body = `{"name": "real", "originalName": "Real", "file": {"id": 7}}`
+			}
+		case strings.Contains(arg, "[3 :file/path"):
+			body = `"pages/page.md"`
+		case strings.Contains(arg, "[7 :file/path"):
+			body = `"pages/real.md"`
+		}
+		if body == "" {
+			http.Error(w, "unexpected request "+method+" "+arg, http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte(body))
+	})
+	tests := []struct {
+		name     string
+		wantURI  string
+		wantName string
+	}{
+		{name: "Other", wantURI: "pages/indexed.md", wantName: "indexed"},
+		{name: "page", wantURI: "pages/page.md", wantName: "Page"},
+		{name: "alias", wantURI: "pages/real.md", wantName: "Real"},
+		// an alias no page declares is guessed from its own name
+		{name: "orphan alias", wantURI: "pages/Orphan Alias.md", wantName: "Orphan Alias"},
+		{name: "missing"},
+		{name: "error"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page, err := gi.resolvePage(test.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.wantURI == "" {
+				if page != nil {
+					t.Errorf("resolvePage = %+v, want nil", page)
+				}
+				return
+			}
+			if page == nil || page.URI != gi.uri(test.wantURI) || page.Name != test.wantName {
+				t.Errorf("resolvePage = %+v, want %s named %s", page, gi.uri(test.wantURI), test.wantName)
+			}
+		})
+	}
+}
+
+func TestExecuteCommandArguments(t *testing.T) {
+	gi := newTestGraph(t, nil)
+	for _, arguments := range [][]any{
+		{"file:///a.md", float64(1)},
+		{"file:///a.md", "1", "id"},
+		{float64(1), float64(1), "id"},
+		{"file:///a.md", float64(1), nil},
+	} {
+		if _, err := gi.executeCommand(nil, &protocol.ExecuteCommandParams{Command: addBlockIDCommand, Arguments: arguments}); err == nil {
+			t.Errorf("executeCommand accepted arguments %v", arguments)
+		}
+	}
+}
+
+func TestHoverAdvancedQueryWithoutQuery(t *testing.T) {
+	gi := newTestGraph(t, nil)
+	serveAPI(t, gi, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("ran an advanced query without a :query")
+		http.Error(w, "unexpected request", http.StatusInternalServerError)
+	})
+	uri := gi.uri("pages/edit.md")
+	if _, err := gi.documents.Open(uri, "- #+BEGIN_QUERY\n  {:title \"Todos\" :inputs [:today]}\n  #+END_QUERY\n"); err != nil {
+		t.Fatal(err)
+	}
+	h, err := gi.hover(nil, &protocol.HoverParams{TextDocumentPositionParams: protocol.TextDocumentPositionParams{
+		TextDocument: protocol.TextDocumentIdentifier{URI: uri},
+		Position:     protocol.Position{Line: 1, Character: 4},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h == nil || !strings.Contains(h.Contents.(protocol.MarkupContent).Value, "**Todos**\n\nThe advanced query has no `:query`") {
+		t.Errorf("hover = %+v, want the query reported as missing", h)
+	}
+}
+
+func TestWatchReloadsConfig(t *testing.T) {
+	gi := newTestGraph(t, map[string]string{"logseq/config.edn": "{}", "notes/a.md": "- text\n"})
+	gi.config.watch = true
+	gi.loadGraph()
+	t.Cleanup(func() {
+		gi.loading.Lock()
+		defer gi.loading.Unlock()
+		gi.stopWatching()
+		gi.stopWatchingConfig()
+	})
+	if err := os.WriteFile(filepath.Join(gi.path, "logseq", "config.edn"), []byte(`{:pages-directory "notes"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for deadline := time.Now().Add(5 * time.Second); gi.graphConfig().PagesDirectory != "notes"; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for config.edn to be reloaded")
+		}
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, ok := gi.index().Page("a"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the pages directory of the new config to be indexed")
+		}
+	}
+}
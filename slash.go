@@ -0,0 +1,225 @@
+package main
+
+import (
+	"github.com/WhiskeyJack96/logseqlsp/document"
+	"github.com/WhiskeyJack96/logseqlsp/graph"
+	protocol "github.com/tliron/glsp/protocol_3_16"
+	"golang.org/x/exp/slog"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// slashCommand is an entry of the / menu, completed to the logseq syntax it stands for.
+type slashCommand struct {
+	name   string
+	detail string
+	// snippet returns the snippet the command is replaced with.
+	snippet func(c slashContext) string
+}
+
+// slashContext is what the snippet of a slash command is built from.
+type slashContext struct {
+	now time.Time
+	// indent is the indentation of the lines following the first line of the block
+	indent string
+	// journal formats a day as a journal page title
+	journal func(t time.Time) string
+}
+
+func literal(s string) func(slashContext) string {
+	return func(slashContext) string {
+		return s
+	}
+}
+
+func journalLink(days int) func(slashContext) string {
+	return func(c slashContext) string {
+		return "[[" + snippetEscape(c.journal(c.now.AddDate(0, 0, days))) + "]]"
+	}
+}
+
+// slashCommands mirror the / menu of the logseq app. Task markers and templates are completed separately as they edit
+// more than the command itself.
+var slashCommands = []slashCommand{
+	{name: "Page reference", detail: "[[page]]", snippet: literal("[[$1]]")},
+	{name: "Page embed", detail: "{{embed [[page]]}}", snippet: literal("{{embed [[$1]]}}")},
+	{name: "Block reference", detail: "((uuid))", snippet: literal("(($1))")},
+	{name: "Block embed", detail: "{{embed ((uuid))}}", snippet: literal("{{embed (($1))}}")},
+	{name: "Date picker", detail: "link to a journal page", snippet: func(c slashContext) string {
+		return "[[${1:" + snippetEscape(c.journal(c.now)) + "}]]"
+	}},
+	{name: "Today", detail: "link to today's journal", snippet: journalLink(0)},
+	{name: "Tomorrow", detail: "link to tomorrow's journal", snippet: journalLink(1)},
+	{name: "Yesterday", detail: "link to yesterday's journal", snippet: journalLink(-1)},
+	{name: "Current time", detail: "HH:mm", snippet: func(c slashContext) string {
+		return c.now.Format("15:04")
+	}},
+	{name: "Query", detail: "{{query}}", snippet: literal("{{query $1}}")},
+	{name: "Advanced Query", detail: "#+BEGIN_QUERY", snippet: func(c slashContext) string {
+		return "#+BEGIN_QUERY\n" + c.indent + "$0\n" + c.indent + "#+END_QUERY"
+	}},
+	{name: "Query function", detail: "{{function}}", snippet: literal("{{function $1}}")},
+	{name: "Link", detail: "[label](url)", snippet: literal("[${2:label}](${1:url})")},
+	{name: "Image link", detail: "![description](url)", snippet: literal("![${2:description}](${1:url})")},
+	{name: "Code block", detail: "```", snippet: func(c slashContext) string {
+		return "```$1\n" + c.indent + "$0\n" + c.indent + "```"
+	}},
+	{name: "Math block", detail: "$$", snippet: func(c slashContext) string {
+		return "\\$\\$\n" + c.indent + "$0\n" + c.indent + "\\$\\$"
+	}},
+	{name: "Cloze", detail: "{{cloze}}", snippet: literal("{{cloze $1}}")},
+	{name: "Video", detail: "{{video url}}", snippet: literal("{{video $1}}")},
+	{name: "Tweet", detail: "{{tweet url}}", snippet: literal("{{tweet $1}}")},
+	{name: "Embed HTML", detail: "@@html: @@", snippet: literal("@@html: $1@@")},
+}
+
+// slashCandidates ranks the slash commands against query. rng covers the command typed at pos, from its slash to the
+// cursor, prefix is the text of the line in front of the slash and rest the text after the cursor.
+func (gi *graphInfo) slashCandidates(d document.Document, pos protocol.Position, rng protocol.Range, prefix string, rest string, query string) []candidate {
+	var candidates []candidate
+	add := func(c candidate, order int) {
+		score, ok := fuzzyScore(query, c.label)
+		if !ok {
+			return
+		}
+		if query == "" {
+			// the menu keeps its order until something is typed
+			score = -order
+		}
+		c.score = score
+		if c.filter == "" {
+			c.filter = "/" + c.label
+		}
+		candidates = append(candidates, c)
+	}
+	b, err := d.FindBlockForPosition(pos)
+	if err != nil {
+		b = nil
+	}
+	ctx := slashContext{
+		now:     time.Now(),
+		indent:  document.LeadingSpace(prefix) + "  ",
+		journal: gi.graphConfig().JournalPageTitleFormat.Format,
+	}
+	order := 0
+	for _, marker := range document.Markers {
+		c := candidate{label: marker, kind: protocol.CompletionItemKindKeyword, detail: "task marker", insert: marker + " "}
+		if b != nil && b.Bullet {
+			// the marker goes in front of the block's text, so the completion rewrites the line from there to the cursor
+			edit := d.MarkerEdit(b, marker)
+			if edit.Range.Start.Line != pos.Line {
+				continue
+			}
+			start, end, slash := d.Offset(edit.Range.Start), d.Offset(edit.Range.End), d.Offset(rng.Start)
+			c.insert = edit.NewText + d.Contents[end:slash]
+			c.filter = d.Contents[start:slash] + "/" + marker
+			c.rng = &protocol.Range{Start: edit.Range.Start, End: rng.End}
+		}
+		add(c, order)
+		order++
+	}
+	for _, command := range slashCommands {
+		c := candidate{label: command.name, kind: protocol.CompletionItemKindSnippet, detail: command.detail, insert: command.snippet(ctx), snippet: true}
+		if !gi.snippets {
+			c.insert, c.snippet = snippetText(c.insert), false
+		}
+		add(c, order)
+		order++
+	}
+	for _, c := range gi.templateCandidates(d, b, pos, prefix, rest, query) {
+		add(c, order)
+		order++
+	}
+	return candidates
+}
+
+// templateCandidates completes the templates of the graph, which replace a block that holds nothing but the command
+// with the blocks of the template, like the template command of the logseq app. Only markdown templates are offered
+// as org blocks are nested by their heading level rather than indentation.
+func (gi *graphInfo) templateCandidates(d document.Document, b *document.Block, pos protocol.Position, prefix string, rest string, query string) []candidate {
+	rest = strings.TrimSuffix(rest, "\r")
+	if b == nil || !b.Bullet || b.Range.Start.Line != pos.Line || d.Format != document.Markdown ||
+		strings.TrimSpace(strings.Join(b.Content, "\n")) != "/"+query {
+		return nil
+	}
+	var templates []graph.Block
+	gi.index().WalkBlocks(func(t graph.Block) bool {
+		if t.Template != "" && document.FormatForPath(t.URI) == document.Markdown {
+			templates = append(templates, t)
+		}
+		return true
+	})
+	lineRange := protocol.Range{Start: protocol.Position{Line: pos.Line}, End: d.Position(d.Offset(pos) + len(rest))}
+	var candidates []candidate
+	for _, t := range templates {
+		text, err := gi.templateText(t, document.LeadingSpace(prefix))
+		if err != nil {
+			gi.logger.Warn("could not read template", slog.Any("err", err), slog.String("template", t.Template))
+			continue
+		}
+		candidates = append(candidates, candidate{
+			label:         "Template: " + t.Template,
+			filter:        prefix + "/Template: " + t.Template,
+			kind:          protocol.CompletionItemKindSnippet,
+			detail:        t.Page,
+			documentation: "```markdown\n" + text + "\n```",
+			insert:        text,
+			rng:           &lineRange,
+		})
+	}
+	return candidates
+}
+
+// templateText reads the blocks of the template t and indents them to indent. The template block itself is left out
+// unless its template-including-parent:: property is true, as are the properties that mark it as a template and the
+// ids of its blocks, which belong to the template rather than the copy.
+func (gi *graphInfo) templateText(t graph.Block, indent string) (string, error) {
+	d, err := gi.readDocument(t.URI)
+	if err != nil {
+		return "", err
+	}
+	var template *document.Block
+	d.Walk(func(b *document.Block) bool {
+		if b.Range.Start.Line == t.Range.Start.Line {
+			template = b
+		}
+		return template == nil
+	})
+	if template == nil {
+		return "", document.ErrBlockNotFound
+	}
+	blocks := template.Children
+	if parent, _ := template.Property("template-including-parent"); parent == "true" {
+		blocks = []*document.Block{template}
+	}
+	var lines []string
+	base := ""
+	for i, b := range blocks {
+		text := strings.TrimRight(d.Contents[b.Start:b.LastDescendant().End], " \t\r\n")
+		for j, l := range strings.Split(text, "\n") {
+			if i == 0 && j == 0 {
+				base = document.LeadingSpace(l)
+			}
+			key := strings.ToLower(strings.TrimSpace(l))
+			if strings.HasPrefix(key, "id::") || strings.HasPrefix(key, "template::") || strings.HasPrefix(key, "template-including-parent::") {
+				continue
+			}
+			lines = append(lines, indent+strings.TrimPrefix(strings.TrimSuffix(l, "\r"), base))
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+var snippetPlaceholderRegex = regexp.MustCompile(`\$\{\d+:([^}]*)}|\$\d+|\\([$}\\])`)
+
+// snippetText turns a snippet into the plain text it inserts before any placeholder is edited, for clients without
+// snippet support.
+func snippetText(snippet string) string {
+	return snippetPlaceholderRegex.ReplaceAllString(snippet, "$1$2")
+}
+
+// snippetEscape escapes the characters that have a meaning in snippets.
+func snippetEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `$`, `\$`, `}`, `\}`).Replace(s)
+}